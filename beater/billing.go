@@ -0,0 +1,140 @@
+package beater
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+// DefaultBatchSize is how many namespaces are queried per billing request
+const DefaultBatchSize = 100
+
+// DefaultBucketBatchSize is same as DefaultBatchSize if bucket detail is on
+const DefaultBucketBatchSize = 10
+
+// DefaultMaxBuckets limits bucket events per namespace
+const DefaultMaxBuckets = 1000
+
+// bucketTypes are event types of buckets per billing command type, and the
+// field of namespace billing listing its buckets
+var bucketTypes = map[string][2]string{
+	"nsbilling":       {"bucketbilling", "bucket_billing_info"},
+	"nsbillingsample": {"bucketbillingsample", "bucket_billing_sample_info"},
+}
+
+// Billing controls which namespaces nsbilling and nsbillingsample query,
+// and whether one event per bucket is emitted too
+type Billing struct {
+	include, exclude []string
+	batchSize        int
+	// maxBuckets is 0 if bucket detail is off
+	maxBuckets int
+	// Buckets is the command bucket events are built with, it's the same as
+	// the billing command except for type and counters
+	Buckets *Command
+}
+
+// NewBilling ...
+func NewBilling(cmd *Command, c *config.Command) (*Billing, error) {
+	b := &Billing{batchSize: c.BatchSize}
+	if c.Namespaces != nil {
+		b.include, b.exclude = c.Namespaces.Include, c.Namespaces.Exclude
+	}
+	for _, p := range append(append([]string{}, b.include...), b.exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("namespace pattern %q: %v", p, err)
+		}
+	}
+	if c.BucketDetail != nil && c.BucketDetail.Enabled {
+		b.maxBuckets = c.BucketDetail.MaxBuckets
+		if b.maxBuckets <= 0 {
+			b.maxBuckets = DefaultMaxBuckets
+		}
+		buckets := *cmd
+		buckets.Type = bucketTypes[cmd.Type][0]
+		buckets.CounterFields = CounterTables[buckets.Type]
+		if c.Counters != nil && c.Counters.Fields != nil {
+			buckets.CounterFields = c.Counters.Fields
+		}
+		if len(buckets.CounterFields) == 0 {
+			buckets.Counters = nil
+		}
+		b.Buckets = &buckets
+	}
+	if b.batchSize <= 0 {
+		b.batchSize = DefaultBatchSize
+		if b.maxBuckets > 0 {
+			b.batchSize = DefaultBucketBatchSize
+		}
+	}
+	return b, nil
+}
+
+func isBucketType(etype string) bool {
+	return etype == "bucketbilling" || etype == "bucketbillingsample"
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Namespaces returns ids included by include patterns, all if there's no
+// include pattern, and not excluded by exclude patterns
+func (b *Billing) Namespaces(ids []string) []string {
+	var result []string
+	for _, id := range ids {
+		if (len(b.include) == 0 || matchAny(b.include, id)) && !matchAny(b.exclude, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// URI sets include_bucket_detail of uri according to bucket detail mode
+func (b *Billing) URI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	q.Set("include_bucket_detail", fmt.Sprint(b.maxBuckets > 0))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// SplitBuckets removes buckets from namespace billing d, and returns at most
+// maxBuckets of them with the namespace and sample time of d
+func (b *Billing) SplitBuckets(etype string, d map[string]interface{}) []map[string]interface{} {
+	field := bucketTypes[etype][1]
+	entries, _ := d[field].([]interface{})
+	delete(d, field)
+	if b.maxBuckets == 0 {
+		return nil
+	}
+	if len(entries) > b.maxBuckets {
+		logp.Warn("%s: namespace %v has %d buckets, only %d are collected", etype, d["namespace"], len(entries), b.maxBuckets)
+		entries = entries[:b.maxBuckets]
+	}
+	var result []map[string]interface{}
+	for _, e := range entries {
+		bucket, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, k := range []string{"namespace", "sample_time", "sample_time_range"} {
+			if _, ok := bucket[k]; !ok && d[k] != nil {
+				bucket[k] = d[k]
+			}
+		}
+		result = append(result, bucket)
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package beater
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestBillingNamespaces ...
+func TestBillingNamespaces(t *testing.T) {
+	b, err := NewBilling(&Command{Type: "nsbilling"}, &config.Command{
+		Namespaces: &config.NamespaceFilter{Include: []string{"prod-*", "dev-*"}, Exclude: []string{"*-tmp"}},
+	})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	ecs.AssertEqual(t, DefaultBatchSize, b.batchSize, "")
+	ecs.AssertEqual(t, []string{"prod-a", "dev-b"}, b.Namespaces([]string{"prod-a", "dev-b", "qa-c", "prod-tmp"}), "")
+	ecs.AssertEqual(t, "/object/billing/namespace/info.json?include_bucket_detail=false",
+		b.URI("/object/billing/namespace/info.json?include_bucket_detail=false"), "")
+
+	_, err = NewBilling(&Command{Type: "nsbilling"}, &config.Command{
+		Namespaces: &config.NamespaceFilter{Exclude: []string{"["}},
+	})
+	ecs.AssertNotEqual(t, nil, err, "")
+}
+
+// TestBillingBuckets ...
+func TestBillingBuckets(t *testing.T) {
+	cmd := &Command{Type: "nsbillingsample"}
+	b, err := NewBilling(cmd, &config.Command{BucketDetail: &config.BucketDetail{Enabled: true, MaxBuckets: 2}})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	ecs.AssertEqual(t, DefaultBucketBatchSize, b.batchSize, "")
+	ecs.AssertEqual(t, "bucketbillingsample", b.Buckets.Type, "")
+	ecs.AssertEqual(t, "/object/billing/namespace/sample.json?include_bucket_detail=true",
+		b.URI("/object/billing/namespace/sample.json?include_bucket_detail=false"), "")
+
+	resp := `{"namespace_billing_sample_infos": [{"namespace": "ns1", "total_size": "1.5", "total_size_unit": "GB",
+		"sample_time_range": {"start_time": "2017-03-01T10:00", "end_time": "2017-03-01T10:05"},
+		"bucket_billing_sample_info": [
+			{"name": "b1", "vpool_id": "rg1", "total_size": "1", "total_size_unit": "GB", "total_objects": "5"},
+			{"name": "b2", "vpool_id": "rg1", "total_size": "0.5", "total_size_unit": "GB", "total_objects": "5"},
+			{"name": "b3", "vpool_id": "rg1", "total_size": "0", "total_size_unit": "GB", "total_objects": "0"}]}]}`
+	decoded, err := DecodeResponse(&http.Response{Body: ioutil.NopCloser(strings.NewReader(resp))})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	buckets := b.SplitBuckets(cmd.Type, decoded[0])
+	ecs.AssertEqualFatal(t, 2, len(buckets), "limited to maxbuckets")
+	_, ok := decoded[0]["bucket_billing_sample_info"]
+	ecs.AssertEqual(t, false, ok, "buckets are removed from namespace")
+
+	_, declared, err := templateFields()
+	ecs.AssertEqualFatal(t, nil, err, "")
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{}}
+	event := buildEvent(b.Buckets, cfg, buckets[0], "", "")[0]
+	ecs.AssertEqual(t, "ns1", event["namespace"], "")
+	ecs.AssertEqual(t, "b1", event["name"], "")
+	ecs.AssertEqual(t, int64(1<<30), event["total_size_bytes"], "")
+	for _, name := range flattenKeys("", event) {
+		ecs.AssertEqual(t, true, inTemplate(declared, name), name)
+	}
+}
+
+// TestBillingBucketsHealth ...
+func TestBillingBucketsHealth(t *testing.T) {
+	c := config.DefaultConfig
+	c.Commands = []*config.Command{
+		{URI: "/object/billing/namespace/info.json", Type: "nsbilling", Level: "system", Enabled: true,
+			BucketDetail: &config.BucketDetail{Enabled: true}},
+		{URI: "dummy", Type: "health", Level: "health", Enabled: true},
+	}
+	registry, _ := NewRegistry("")
+	ec, err := NewEcsClusters(c, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	cmd := ec.Cmds[0]
+	ecs.AssertEqualFatal(t, true, cmd.Health != nil, "")
+	ecs.AssertEqual(t, cmd.Health, cmd.Billing.Buckets.Health, "bucket events are observed too")
+}
@@ -0,0 +1,134 @@
+package beater
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+const capacityKey = "capacity"
+
+// maxCapacitySamples bounds capacity history kept per storage pool
+const maxCapacitySamples = 500
+
+// capacityFields are names of total and free capacity per command type
+var capacityFields = map[string][2]string{
+	"storagepools": {"diskSpaceTotalCurrent_bytes", "diskSpaceFreeCurrent_bytes"},
+	"localzone":    {"diskSpaceTotalCurrent_bytes", "diskSpaceFreeCurrent_bytes"},
+	"capacity":     {"totalProvisioned_bytes", "totalFree_bytes"},
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	f, err := castValue(v, "double")
+	if err != nil {
+		return 0, false
+	}
+	return f.(float64), true
+}
+
+// deriveCapacity adds used capacity and utilisation to storage pool, VDC and
+// customer capacity events. It returns used and total capacity.
+func deriveCapacity(etype string, event map[string]interface{}) (used, total float64, ok bool) {
+	names, ok := capacityFields[etype]
+	if !ok {
+		return 0, 0, false
+	}
+	total, ok1 := toFloat(event[names[0]])
+	free, ok2 := toFloat(event[names[1]])
+	if !ok1 || !ok2 || total <= 0 {
+		return 0, 0, false
+	}
+	used = total - free
+	event["capacityUsed_bytes"] = int64(used)
+	event["capacityUtilization_ratio"] = used / total
+	return used, total, true
+}
+
+type capacitySample struct {
+	T    time.Time `json:"t"`
+	Used float64   `json:"used"`
+}
+
+// Forecaster keeps a rolling history of used capacity per storage pool, and
+// estimates growth rate and days until the pool reaches thresholds
+type Forecaster struct {
+	mutex      sync.Mutex
+	registry   *Registry
+	history    time.Duration
+	thresholds []float64
+	samples    map[string][]capacitySample
+}
+
+// NewForecaster loads capacity history from registry
+func NewForecaster(registry *Registry, c config.Forecast) *Forecaster {
+	f := &Forecaster{
+		registry:   registry,
+		history:    c.History,
+		thresholds: c.Thresholds,
+		samples:    make(map[string][]capacitySample),
+	}
+	registry.Get(capacityKey, &f.samples)
+	return f
+}
+
+// Update records used capacity of the storage pool key at now, and returns
+// fields of the forecast event
+func (f *Forecaster) Update(key string, now time.Time, used, total float64) common.MapStr {
+	f.mutex.Lock()
+	samples := f.samples[key]
+	// drop samples out of history, and keep at most maxCapacitySamples
+	// evenly spread in the history
+	for len(samples) > 0 && now.Sub(samples[0].T) > f.history {
+		samples = samples[1:]
+	}
+	if len(samples) == 0 || now.Sub(samples[len(samples)-1].T) >= f.history/maxCapacitySamples {
+		samples = append(samples, capacitySample{now, used})
+		if len(samples) > maxCapacitySamples {
+			samples = samples[1:]
+		}
+		f.samples[key] = samples
+		if err := f.registry.Set(capacityKey, f.samples); err != nil {
+			logp.Err("failed to save capacity history: %v", err)
+		}
+	}
+	samples = append([]capacitySample{}, samples...)
+	f.mutex.Unlock()
+
+	event := common.MapStr{
+		"capacityUsed_bytes":        int64(used),
+		"capacityTotal_bytes":       int64(total),
+		"capacityUtilization_ratio": used / total,
+		"forecastSamples":           len(samples),
+	}
+	if len(samples) < 2 {
+		return event
+	}
+	// growth rate is the slope of least squares fit of used capacity over time
+	var sumX, sumY, sumXY, sumXX float64
+	n := float64(len(samples))
+	for _, s := range samples {
+		x := s.T.Sub(samples[0].T).Hours() / 24
+		sumX += x
+		sumY += s.Used
+		sumXY += x * s.Used
+		sumXX += x * x
+	}
+	if d := n*sumXX - sumX*sumX; d > 0 {
+		rate := (n*sumXY - sumX*sumY) / d
+		event["growthRate_bytes_per_day"] = rate
+		for _, t := range f.thresholds {
+			name := fmt.Sprintf("daysTo%.0fPercent", t*100)
+			switch target := t * total; {
+			case used >= target:
+				event[name] = 0.0
+			case rate > 0:
+				event[name] = (target - used) / rate
+			}
+		}
+	}
+	return event
+}
@@ -0,0 +1,49 @@
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestDeriveCapacity ...
+func TestDeriveCapacity(t *testing.T) {
+	event := map[string]interface{}{"diskSpaceTotalCurrent_bytes": int64(100), "diskSpaceFreeCurrent_bytes": int64(25)}
+	used, total, ok := deriveCapacity("storagepools", event)
+	ecs.AssertEqual(t, true, ok, "")
+	ecs.AssertEqual(t, 75.0, used, "")
+	ecs.AssertEqual(t, 100.0, total, "")
+	ecs.AssertEqual(t, int64(75), event["capacityUsed_bytes"], "")
+	ecs.AssertEqual(t, 0.75, event["capacityUtilization_ratio"], "")
+
+	_, _, ok = deriveCapacity("storagepools", map[string]interface{}{"diskSpaceFreeCurrent_bytes": int64(25)})
+	ecs.AssertEqual(t, false, ok, "total is missing")
+	_, _, ok = deriveCapacity("nodes", event)
+	ecs.AssertEqual(t, false, ok, "")
+}
+
+// TestForecaster ...
+func TestForecaster(t *testing.T) {
+	registry, _ := NewRegistry("")
+	f := NewForecaster(registry, config.Forecast{History: 7 * 24 * time.Hour, Thresholds: []float64{0.5, 0.8}})
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	event := f.Update("c1/storagepools/VDC1/sp1", now, 400, 1000)
+	ecs.AssertEqual(t, 1, event["forecastSamples"], "")
+	_, ok := event["growthRate_bytes_per_day"]
+	ecs.AssertEqual(t, false, ok, "no forecast from a single sample")
+
+	// used capacity grows 100 bytes per day
+	event = f.Update("c1/storagepools/VDC1/sp1", now.Add(24*time.Hour), 500, 1000)
+	ecs.AssertEqual(t, 100.0, event["growthRate_bytes_per_day"], "")
+	ecs.AssertEqual(t, 0.0, event["daysTo50Percent"], "")
+	ecs.AssertEqual(t, 3.0, event["daysTo80Percent"], "")
+
+	// history survives restarts, samples older than history are dropped
+	f = NewForecaster(registry, config.Forecast{History: 7 * 24 * time.Hour, Thresholds: []float64{0.8}})
+	event = f.Update("c1/storagepools/VDC1/sp1", now.Add(2*24*time.Hour), 600, 1000)
+	ecs.AssertEqual(t, 3, event["forecastSamples"], "")
+	event = f.Update("c1/storagepools/VDC1/sp1", now.Add(9*24*time.Hour), 600, 1000)
+	ecs.AssertEqual(t, 2, event["forecastSamples"], "")
+}
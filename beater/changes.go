@@ -0,0 +1,230 @@
+package beater
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const topologyKey = "topology"
+
+// maxPendingChanges bounds change events waiting to be published
+const maxPendingChanges = 1024
+
+// ChangeFields are fields of change events
+var ChangeFields = []SchemaField{
+	{"change-type", "keyword", "Type of the change: vdc_renamed, node_added, node_removed, node_status_changed, version_changed or disk_count_changed."},
+	{"change-subject", "keyword", "Name of the VDC or node changed."},
+	{"change-before", "keyword", "Value before the change, absent if the node is added."},
+	{"change-after", "keyword", "Value after the change, absent if the node is removed."},
+}
+
+type nodeSnapshot struct {
+	IP      string `json:"ip"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Status  string `json:"status"`
+}
+
+// topology is the state of a customer compared across refreshes
+type topology struct {
+	// VDC names by VDC config name
+	VdcNames map[string]string `json:"vdc_names"`
+	// nodes and disk counts by VDC config name, then by node ID
+	Nodes map[string]map[string]nodeSnapshot `json:"nodes"`
+	Disks map[string]map[string]int          `json:"disks"`
+}
+
+// ChangeDetector compares VDCs, nodes and disks with their previous state,
+// and publishes change events when they differ
+type ChangeDetector struct {
+	mutex    sync.Mutex
+	registry *Registry
+	state    map[string]*topology
+	events   chan common.MapStr
+	closed   chan struct{}
+}
+
+// NewChangeDetector loads the previous state from registry
+func NewChangeDetector(registry *Registry) *ChangeDetector {
+	d := &ChangeDetector{
+		registry: registry,
+		state:    make(map[string]*topology),
+		events:   make(chan common.MapStr, maxPendingChanges),
+		closed:   make(chan struct{}),
+	}
+	registry.Get(topologyKey, &d.state)
+	return d
+}
+
+// Start forwards change events until done is closed, or until Close is
+// called and changes detected so far are forwarded
+func (d *ChangeDetector) Start(done <-chan struct{}) <-chan common.MapStr {
+	out := make(chan common.MapStr, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			case event := <-d.events:
+				if !writeEvent(done, out, event) {
+					return
+				}
+			case <-d.closed:
+				for {
+					select {
+					case event := <-d.events:
+						if !writeEvent(done, out, event) {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Close ends the stream of Start once pending changes are forwarded, it's
+// called when nothing observes changes anymore
+func (d *ChangeDetector) Close() {
+	close(d.closed)
+}
+
+func (d *ChangeDetector) topology(customer string) *topology {
+	t, ok := d.state[customer]
+	if !ok {
+		t = &topology{}
+		d.state[customer] = t
+	}
+	if t.VdcNames == nil {
+		t.VdcNames = make(map[string]string)
+	}
+	if t.Nodes == nil {
+		t.Nodes = make(map[string]map[string]nodeSnapshot)
+	}
+	if t.Disks == nil {
+		t.Disks = make(map[string]map[string]int)
+	}
+	return t
+}
+
+func (d *ChangeDetector) emit(config *ClusterConfig, vdc, node, ctype, subject string, before, after interface{}) {
+	event := common.MapStr{"change-type": ctype, "change-subject": subject}
+	if before != nil {
+		event["change-before"] = fmt.Sprint(before)
+	}
+	if after != nil {
+		event["change-after"] = fmt.Sprint(after)
+	}
+	addCommonFields(event, config, vdc, node, "change")
+	select {
+	case d.events <- event:
+	default:
+		logp.Warn("too many pending change events, %s of %s is dropped", ctype, subject)
+	}
+}
+
+func (d *ChangeDetector) save() {
+	if err := d.registry.Set(topologyKey, d.state); err != nil {
+		logp.Err("failed to save topology: %v", err)
+	}
+}
+
+// ObserveVdc compares name of VDC vdc with its previous name
+func (d *ChangeDetector) ObserveVdc(config *ClusterConfig, vdc, name string) {
+	if d == nil || name == "" {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	t := d.topology(config.CustomerName)
+	if prev, ok := t.VdcNames[vdc]; ok && prev != name {
+		d.emit(config, vdc, "", "vdc_renamed", vdc, prev, name)
+	}
+	if t.VdcNames[vdc] != name {
+		t.VdcNames[vdc] = name
+		d.save()
+	}
+}
+
+// ObserveNodes compares all nodes of VDC vdc by node ID with their previous
+// state. Nothing is reported the first time nodes of a VDC are observed.
+func (d *ChangeDetector) ObserveNodes(config *ClusterConfig, vdc string, nodes map[string]nodeSnapshot) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	t := d.topology(config.CustomerName)
+	prev, ok := t.Nodes[vdc]
+	t.Nodes[vdc] = nodes
+	if !ok {
+		d.save()
+		return
+	}
+
+	ids := make([]string, 0, len(prev)+len(nodes))
+	for id := range prev {
+		ids = append(ids, id)
+	}
+	for id := range nodes {
+		if _, ok := prev[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var changed bool
+	for _, id := range ids {
+		before, wasThere := prev[id]
+		after, isThere := nodes[id]
+		switch {
+		case !wasThere:
+			d.emit(config, vdc, after.IP, "node_added", after.Name, nil, after.IP)
+		case !isThere:
+			d.emit(config, vdc, before.IP, "node_removed", before.Name, before.IP, nil)
+		default:
+			if before.Status != after.Status {
+				d.emit(config, vdc, after.IP, "node_status_changed", after.Name, before.Status, after.Status)
+			}
+			if before.Version != after.Version {
+				d.emit(config, vdc, after.IP, "version_changed", after.Name, before.Version, after.Version)
+			}
+			changed = changed || before != after
+			continue
+		}
+		changed = true
+	}
+	if changed {
+		d.save()
+	}
+}
+
+// ObserveDisks compares number of disks of node with its previous number
+func (d *ChangeDetector) ObserveDisks(config *ClusterConfig, vdc string, node *Node, count int) {
+	if d == nil {
+		return
+	}
+	id, ip, name, _ := node.Get()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	t := d.topology(config.CustomerName)
+	if t.Disks[vdc] == nil {
+		t.Disks[vdc] = make(map[string]int)
+	}
+	prev, ok := t.Disks[vdc][id]
+	if ok && prev == count {
+		return
+	}
+	if ok {
+		d.emit(config, vdc, ip, "disk_count_changed", name, prev, count)
+	}
+	t.Disks[vdc][id] = count
+	d.save()
+}
@@ -0,0 +1,86 @@
+package beater
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+func pendingChanges(d *ChangeDetector) []common.MapStr {
+	var result []common.MapStr
+	// every call forwards changes detected since the previous one
+	d.closed = make(chan struct{})
+	d.Close()
+	for event := range d.Start(nil) {
+		result = append(result, event)
+	}
+	return result
+}
+
+// TestChangeDetector ...
+func TestChangeDetector(t *testing.T) {
+	registry, _ := NewRegistry("")
+	d := NewChangeDetector(registry)
+	node := &Node{ID: "n1", IP: "1.1.1.1", Name: "node1"}
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{
+		"VDC1": {ConfigName: "VDC1", NodeInfo: map[string]*Node{node.IP: node}},
+	}}
+
+	// nothing is reported the first time
+	d.ObserveVdc(cfg, "VDC1", "vdc1")
+	d.ObserveNodes(cfg, "VDC1", map[string]nodeSnapshot{
+		"n1": {IP: "1.1.1.1", Name: "node1", Version: "3.0", Status: "Good"},
+		"n2": {IP: "2.2.2.2", Name: "node2", Version: "3.0", Status: "Good"},
+	})
+	d.ObserveDisks(cfg, "VDC1", node, 12)
+	ecs.AssertEqual(t, 0, len(pendingChanges(d)), "")
+
+	// previous state survives restarts
+	d = NewChangeDetector(registry)
+	d.ObserveVdc(cfg, "VDC1", "vdc1-renamed")
+	d.ObserveNodes(cfg, "VDC1", map[string]nodeSnapshot{
+		"n1": {IP: "1.1.1.1", Name: "node1", Version: "3.1", Status: "Bad"},
+		"n3": {IP: "3.3.3.3", Name: "node3", Version: "3.1", Status: "Good"},
+	})
+	d.ObserveDisks(cfg, "VDC1", node, 11)
+	d.ObserveDisks(cfg, "VDC1", node, 11)
+	changes := pendingChanges(d)
+	ecs.AssertEqualFatal(t, 6, len(changes), "")
+	for i, expected := range [][3]interface{}{
+		{"vdc_renamed", "vdc1", "vdc1-renamed"},
+		{"node_status_changed", "Good", "Bad"},
+		{"version_changed", "3.0", "3.1"},
+		{"node_removed", "2.2.2.2", nil},
+		{"node_added", nil, "3.3.3.3"},
+		{"disk_count_changed", "12", "11"},
+	} {
+		ecs.AssertEqual(t, expected[0], changes[i]["change-type"], "")
+		ecs.AssertEqual(t, expected[1], changes[i]["change-before"], expected[0].(string))
+		ecs.AssertEqual(t, expected[2], changes[i]["change-after"], expected[0].(string))
+		ecs.AssertEqual(t, "change", changes[i]["ecs-event-type"], "")
+	}
+	ecs.AssertEqual(t, "node1", changes[1]["ecs-node-name"], "")
+}
+
+// TestChangeDetectorClose ...
+func TestChangeDetectorClose(t *testing.T) {
+	registry, _ := NewRegistry("")
+	d := NewChangeDetector(registry)
+	node := &Node{ID: "n1", IP: "1.1.1.1"}
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{
+		"VDC1": {ConfigName: "VDC1", NodeInfo: map[string]*Node{node.IP: node}},
+	}}
+	d.ObserveDisks(cfg, "VDC1", node, 10)
+
+	// changes observed after the stream starts are forwarded until it's closed
+	out := d.Start(nil)
+	d.ObserveDisks(cfg, "VDC1", node, 9)
+	d.Close()
+	var changes []common.MapStr
+	for event := range out {
+		changes = append(changes, event)
+	}
+	ecs.AssertEqualFatal(t, 1, len(changes), "")
+	ecs.AssertEqual(t, "disk_count_changed", changes[0]["change-type"], "")
+}
@@ -0,0 +1,105 @@
+package beater
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const checkpointsKey = "checkpoints"
+
+// DefaultMaxLookback is how far back time windowed commands catch up by default
+const DefaultMaxLookback = 24 * time.Hour
+
+// maxWindows is how many windows are queried per fetch, the rest are caught
+// up by the following fetches
+const maxWindows = 60
+
+// Checkpoints records the end of the last successfully published window of
+// time windowed commands, per customer, command and VDC
+type Checkpoints struct {
+	mutex    sync.Mutex
+	registry *Registry
+	ends     map[string]time.Time
+}
+
+// NewCheckpoints loads checkpoints from registry
+func NewCheckpoints(registry *Registry) *Checkpoints {
+	c := &Checkpoints{registry: registry, ends: make(map[string]time.Time)}
+	registry.Get(checkpointsKey, &c.ends)
+	return c
+}
+
+// Get ...
+func (c *Checkpoints) Get(key string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t, ok := c.ends[key]
+	return t, ok
+}
+
+// Set ...
+func (c *Checkpoints) Set(key string, end time.Time) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ends[key] = end
+	if err := c.registry.Set(checkpointsKey, c.ends); err != nil {
+		logp.Err("failed to save checkpoint %s: %v", key, err)
+	}
+}
+
+// window is the time range [start, end) queried by time windowed commands
+type window struct {
+	start, end time.Time
+}
+
+func isWindowed(cmd *Command) bool {
+	switch cmd.Type {
+	case "alert", "auditevent", "nsbillingsample":
+		return true
+	}
+	return false
+}
+
+func checkpointKey(cmd *Command, config *ClusterConfig, vdc string) string {
+	return fmt.Sprintf("%s/%s/%s", config.CustomerName, cmd.Type, vdc)
+}
+
+// getWindows returns windows to query from the last checkpoint up to now,
+// the gap is split into at most maxWindows windows no longer than the
+// command interval
+func getWindows(cmd *Command, key string, now time.Time) []window {
+	// ECS only accepts minutes for alert and auditevent, and 5 minutes for
+	// nsbillingsample. -step to make sure the round time won't be in the future
+	step := time.Minute
+	if cmd.Type == "nsbillingsample" {
+		step = 5 * time.Minute
+	}
+	end := now.Add(-step).Round(step)
+	start := end.Add(-cmd.Interval)
+	if last, ok := cmd.Checkpoints.Get(key); ok {
+		start = last
+	}
+	if earliest := end.Add(-cmd.MaxLookback); cmd.MaxLookback > 0 && start.Before(earliest) {
+		logp.Warn("%s: checkpoint %s is beyond max look-back, catch up from %s", key, start, earliest)
+		start = earliest
+	}
+
+	var result []window
+	for s := start; s.Before(end) && len(result) < maxWindows; s = s.Add(cmd.Interval) {
+		e := s.Add(cmd.Interval)
+		if e.After(end) {
+			e = end
+		}
+		result = append(result, window{s, e})
+	}
+	return result
+}
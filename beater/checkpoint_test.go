@@ -0,0 +1,63 @@
+package beater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestGetWindows ...
+func TestGetWindows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecsbeat")
+	ecs.AssertEqualFatal(t, nil, err, "")
+	defer os.RemoveAll(dir)
+	registry, err := NewRegistry(filepath.Join(dir, "registry"))
+	ecs.AssertEqualFatal(t, nil, err, "")
+
+	cmd := &Command{
+		Type:        "alert",
+		Interval:    time.Minute,
+		Checkpoints: NewCheckpoints(registry),
+		MaxLookback: 10 * time.Minute,
+	}
+	now := time.Date(2017, 3, 1, 10, 30, 10, 0, time.UTC)
+	end := time.Date(2017, 3, 1, 10, 29, 0, 0, time.UTC)
+
+	// no checkpoint, one interval only
+	ecs.AssertEqual(t, []window{{end.Add(-time.Minute), end}}, getWindows(cmd, "k", now), "")
+
+	// gap since checkpoint is split by interval
+	cmd.Checkpoints.Set("k", end.Add(-3*time.Minute))
+	ws := getWindows(cmd, "k", now)
+	ecs.AssertEqual(t, 3, len(ws), "")
+	ecs.AssertEqual(t, end, ws[2].end, "")
+
+	// gap is limited by max look-back
+	cmd.Checkpoints.Set("k", end.Add(-time.Hour))
+	ws = getWindows(cmd, "k", now)
+	ecs.AssertEqual(t, 10, len(ws), "")
+	ecs.AssertEqual(t, end.Add(-10*time.Minute), ws[0].start, "")
+
+	// a long gap is caught up by several fetches
+	cmd.MaxLookback = 0
+	cmd.Checkpoints.Set("k", end.Add(-72*time.Hour))
+	ws = getWindows(cmd, "k", now)
+	ecs.AssertEqual(t, maxWindows, len(ws), "")
+	ecs.AssertEqual(t, end.Add(-72*time.Hour), ws[0].start, "")
+	cmd.MaxLookback = 10 * time.Minute
+
+	// nothing to do if checkpoint is already at the end
+	cmd.Checkpoints.Set("k", end)
+	ecs.AssertEqual(t, 0, len(getWindows(cmd, "k", now)), "")
+
+	// checkpoints survive restarts
+	registry, err = NewRegistry(filepath.Join(dir, "registry"))
+	ecs.AssertEqualFatal(t, nil, err, "")
+	last, ok := NewCheckpoints(registry).Get("k")
+	ecs.AssertEqual(t, true, ok, "")
+	ecs.AssertEqual(t, true, end.Equal(last), "")
+}
@@ -0,0 +1,141 @@
+package beater
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const countersKey = "counters"
+
+// DefaultCounterGap is how many intervals may be missed before the previous
+// value of a counter is too old to compute its delta from
+const DefaultCounterGap = 3
+
+// maxCounterAge is how long previous values of counters no longer polled,
+// like the ones of deleted namespaces, are kept after the latest value
+const maxCounterAge = 7 * 24 * time.Hour
+
+// CounterTables declares cumulative counters per command type, fields are
+// named after normalisation to canonical units. Transaction metrics of the
+// dashboard (transactions per second, bandwidth and error rates) are rates
+// computed by ECS over its own sampling period rather than cumulative
+// counts, so dashboard types have none by default, and deltas of them would
+// be meaningless. counters.fields of a command still turns any field into one.
+var CounterTables = map[string][]string{
+	"nsbilling":           {"total_objects", "total_size_bytes"},
+	"nsbillingsample":     {"total_objects", "total_size_bytes"},
+	"bucketbilling":       {"total_objects", "total_size_bytes"},
+	"bucketbillingsample": {"total_objects", "total_size_bytes"},
+}
+
+type counterValue struct {
+	T time.Time `json:"t"`
+	V float64   `json:"v"`
+}
+
+// Counters keeps the previous value of cumulative counters per series, to
+// add their increase and rate per second since the previous poll
+type Counters struct {
+	mutex    sync.Mutex
+	registry *Registry
+	values   map[string]counterValue
+	dirty    bool
+}
+
+// NewCounters loads previous values of counters from registry
+func NewCounters(registry *Registry) *Counters {
+	c := &Counters{registry: registry, values: make(map[string]counterValue)}
+	registry.Get(countersKey, &c.values)
+	return c
+}
+
+// counterKey identifies the series of event, counter fields are appended to it
+func counterKey(cmd *Command, config *ClusterConfig, vdc, node string, event map[string]interface{}) string {
+	series, ok := event["namespace"]
+	if !ok {
+		series = event["id"]
+	}
+	if series == nil {
+		series = ""
+	}
+	if isBucketType(cmd.Type) {
+		series = fmt.Sprintf("%v/%v", series, event["name"])
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%v", cmd.Type, config.CustomerName, vdc, node, series)
+}
+
+// Apply adds <field>_delta and <field>_rate of every counter field of event
+// sampled at t. A counter lower than its previous value is considered reset,
+// so the delta is the value itself. Nothing is added for the first value of
+// a counter, and for values more than cmd.CounterGap intervals apart.
+func (c *Counters) Apply(cmd *Command, key string, event map[string]interface{}, t time.Time) {
+	var fields []string
+	for k := range event {
+		for _, pattern := range cmd.CounterFields {
+			if ok, _ := path.Match(pattern, k); ok {
+				fields = append(fields, k)
+				break
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, f := range fields {
+		v, ok := toFloat(event[f])
+		if !ok {
+			continue
+		}
+		k := key + "/" + f
+		prev, ok := c.values[k]
+		if ok && !t.After(prev.T) {
+			// same sample polled again
+			continue
+		}
+		c.values[k] = counterValue{t, v}
+		c.dirty = true
+		elapsed := t.Sub(prev.T)
+		if !ok || elapsed > time.Duration(cmd.CounterGap)*cmd.Interval {
+			continue
+		}
+		delta := v - prev.V
+		if delta < 0 {
+			delta = v
+		}
+		event[f+"_delta"] = delta
+		event[f+"_rate"] = delta / elapsed.Seconds()
+	}
+}
+
+// Save persists previous values of counters, it's called once per poll
+func (c *Counters) Save() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.dirty {
+		return
+	}
+	var latest time.Time
+	for _, v := range c.values {
+		if v.T.After(latest) {
+			latest = v.T
+		}
+	}
+	for k, v := range c.values {
+		if latest.Sub(v.T) > maxCounterAge {
+			delete(c.values, k)
+		}
+	}
+	if err := c.registry.Set(countersKey, c.values); err != nil {
+		logp.Err("failed to save counters: %v", err)
+	}
+	c.dirty = false
+}
@@ -0,0 +1,65 @@
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestCounters ...
+func TestCounters(t *testing.T) {
+	registry, _ := NewRegistry("")
+	c := NewCounters(registry)
+	cmd := &Command{Type: "nsbilling", Interval: time.Minute, CounterFields: []string{"total_*"}, CounterGap: 3}
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	apply := func(at time.Time, objects int64) map[string]interface{} {
+		event := map[string]interface{}{"namespace": "ns1", "total_objects": objects}
+		c.Apply(cmd, "nsbilling/c1/VDC1//ns1", event, at)
+		return event
+	}
+
+	_, ok := apply(now, 100)["total_objects_delta"]
+	ecs.AssertEqual(t, false, ok, "no delta of first value")
+
+	event := apply(now.Add(time.Minute), 160)
+	ecs.AssertEqual(t, 60.0, event["total_objects_delta"], "")
+	ecs.AssertEqual(t, 1.0, event["total_objects_rate"], "")
+
+	// same sample polled again
+	_, ok = apply(now.Add(time.Minute), 160)["total_objects_delta"]
+	ecs.AssertEqual(t, false, ok, "")
+
+	// counter reset
+	event = apply(now.Add(2*time.Minute), 30)
+	ecs.AssertEqual(t, 30.0, event["total_objects_delta"], "")
+
+	// previous value survives restarts, but is too old after a long gap
+	c.Save()
+	c = NewCounters(registry)
+	event = apply(now.Add(4*time.Minute), 90)
+	ecs.AssertEqual(t, 60.0, event["total_objects_delta"], "")
+	ecs.AssertEqual(t, 0.5, event["total_objects_rate"], "")
+	_, ok = apply(now.Add(10*time.Minute), 100)["total_objects_delta"]
+	ecs.AssertEqual(t, false, ok, "gap longer than 3 intervals")
+}
+
+// TestCounterTables ...
+func TestCounterTables(t *testing.T) {
+	// dashboard transaction metrics are rates already
+	for _, etype := range []string{"localzone", "nodes", "disks", "processes"} {
+		ecs.AssertEqual(t, 0, len(CounterTables[etype]), etype)
+	}
+
+	// but dashboard commands take counters set by config
+	registry, _ := NewRegistry("")
+	c := NewCounters(registry)
+	cmd := &Command{Type: "localzone", Interval: time.Minute, CounterFields: []string{"numBadDisks"}, CounterGap: 3}
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	c.Apply(cmd, "localzone/c1/VDC1//", map[string]interface{}{"numBadDisks": 1}, now)
+	event := map[string]interface{}{"numBadDisks": 3, "transactionReadTransactionsPerSecCurrent_TPS": 5.0}
+	c.Apply(cmd, "localzone/c1/VDC1//", event, now.Add(time.Minute))
+	ecs.AssertEqual(t, 2.0, event["numBadDisks_delta"], "")
+	_, ok := event["transactionReadTransactionsPerSecCurrent_TPS_delta"]
+	ecs.AssertEqual(t, false, ok, "")
+}
@@ -0,0 +1,117 @@
+package beater
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const dedupKey = "dedup"
+
+// Dedup remembers IDs of recently published alerts and audit events per
+// customer, so that the same event returned by overlapping windows or
+// retries is published only once
+type Dedup struct {
+	mutex    sync.Mutex
+	registry *Registry
+	size     int
+	// IDs in the order they were seen, oldest first, per customer
+	ids  map[string][]string
+	seen map[string]map[string]struct{}
+}
+
+// NewDedup loads IDs seen before from registry
+func NewDedup(registry *Registry, size int) *Dedup {
+	d := &Dedup{
+		registry: registry,
+		size:     size,
+		ids:      make(map[string][]string),
+		seen:     make(map[string]map[string]struct{}),
+	}
+	registry.Get(dedupKey, &d.ids)
+	for customer, ids := range d.ids {
+		d.seen[customer] = make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			d.seen[customer][id] = struct{}{}
+		}
+	}
+	return d
+}
+
+// Seen tells whether id was published for customer
+func (d *Dedup) Seen(customer, id string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, ok := d.seen[customer][id]
+	return ok
+}
+
+// Add remembers id as published for customer, it's called once the event is
+// forwarded. The oldest id of customer is forgotten once the cache is full.
+func (d *Dedup) Add(customer, id string) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	seen, ok := d.seen[customer]
+	if !ok {
+		seen = make(map[string]struct{})
+		d.seen[customer] = seen
+	}
+	if _, ok := seen[id]; ok {
+		return
+	}
+	seen[id] = struct{}{}
+	ids := append(d.ids[customer], id)
+	if len(ids) > d.size {
+		delete(seen, ids[0])
+		ids = ids[1:]
+	}
+	d.ids[customer] = ids
+}
+
+// Save persists the cache, it's called once per published window and once
+// per fetch
+func (d *Dedup) Save() {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err := d.registry.Set(dedupKey, d.ids); err != nil {
+		logp.Err("failed to save dedup cache: %v", err)
+	}
+}
+
+func isDeduplicated(cmd *Command) bool {
+	return cmd.Type == "alert" || cmd.Type == "latestalert" || cmd.Type == "auditevent"
+}
+
+// dedupID returns the ID record d of cmd is deduplicated by, and false if d
+// isn't. latestalert returns the same alerts every poll, so an alert is
+// published again once it's acknowledged.
+func dedupID(cmd *Command, d map[string]interface{}) (string, bool) {
+	if cmd.Dedup == nil {
+		return "", false
+	}
+	id, ok := d["id"].(string)
+	if !ok {
+		return "", false
+	}
+	if cmd.Type == "latestalert" {
+		return fmt.Sprintf("%s/%s/%v", cmd.Type, id, d["acknowledged"]), true
+	}
+	return cmd.Type + "/" + id, true
+}
+
+// docID builds a deterministic document ID from parts, so that Elasticsearch
+// overwrites rather than duplicates the same event published twice
+func docID(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "/")))
+	return hex.EncodeToString(sum[:])
+}
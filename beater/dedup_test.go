@@ -0,0 +1,75 @@
+package beater
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestDedup ...
+func TestDedup(t *testing.T) {
+	registry, _ := NewRegistry("")
+	d := NewDedup(registry, 2)
+	ecs.AssertEqual(t, false, d.Seen("c1", "alert/1"), "")
+	// remembered once added only
+	ecs.AssertEqual(t, false, d.Seen("c1", "alert/1"), "")
+	d.Add("c1", "alert/1")
+	ecs.AssertEqual(t, true, d.Seen("c1", "alert/1"), "")
+	ecs.AssertEqual(t, false, d.Seen("c2", "alert/1"), "")
+	d.Add("c2", "alert/1")
+	d.Add("c1", "alert/2")
+	// oldest id is forgotten once cache is full
+	d.Add("c1", "alert/3")
+	ecs.AssertEqual(t, false, d.Seen("c1", "alert/1"), "")
+
+	d.Save()
+	d = NewDedup(registry, 2)
+	ecs.AssertEqual(t, true, d.Seen("c1", "alert/3"), "")
+	ecs.AssertEqual(t, true, d.Seen("c2", "alert/1"), "")
+
+	ecs.AssertEqual(t, docID("c1", "alert", "1"), docID("c1", "alert", "1"), "")
+	ecs.AssertNotEqual(t, docID("c1", "alert", "1"), docID("c2", "alert", "1"), "")
+}
+
+// TestQueryDedup ...
+func TestQueryDedup(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		acked bool
+	)
+	cluster, stop := fakeEcs(t, "c1", time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"alert": []map[string]interface{}{
+			{"id": "a1", "severity": "CRITICAL", "acknowledged": acked},
+		}})
+	})
+	defer stop()
+	registry, _ := NewRegistry("")
+	cmd := &Command{URI: "/vdc/alerts/latest.json", Type: "latestalert", Level: "vdc", Mapping: &Mapping{}, Dedup: NewDedup(registry, 10)}
+	poll := func() int {
+		out := make(chan common.MapStr, 10)
+		torun, err := GenerateEvents(cmd, cluster.Config, cluster.Client, make(chan struct{}), out)
+		ecs.AssertEqual(t, true, torun, "")
+		ecs.AssertEqual(t, nil, err, "")
+		return len(out)
+	}
+
+	// not remembered if not forwarded
+	done := make(chan struct{})
+	close(done)
+	torun, _ := GenerateEvents(cmd, cluster.Config, cluster.Client, done, make(chan common.MapStr))
+	ecs.AssertEqual(t, false, torun, "")
+	ecs.AssertEqual(t, 1, poll(), "")
+	ecs.AssertEqual(t, 0, poll(), "latestalert returns the same alert every poll")
+
+	mutex.Lock()
+	acked = true
+	mutex.Unlock()
+	ecs.AssertEqual(t, 1, poll(), "published again once acknowledged")
+}
@@ -0,0 +1,236 @@
+package beater
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// dtNodeResult is DT ownership and DT init stat reported by one node
+type dtNodeResult struct {
+	ip           string
+	infos, inits *ecs.DtInfos
+	err          error
+}
+
+// queryDtNodes queries diagnostic ports of all nodes concurrently
+func queryDtNodes(client *ecs.MgmtClient, ips []string) []dtNodeResult {
+	results := make([]dtNodeResult, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(r *dtNodeResult, ip string) {
+			defer wg.Done()
+			r.ip = ip
+			if r.infos, r.err = ecs.GetDtInfos(client, ip); r.err != nil {
+				return
+			}
+			r.inits, r.err = ecs.GetDtInits(client, ip)
+		}(&results[i], ip)
+	}
+	wg.Wait()
+	return results
+}
+
+// dtStatusRank orders DT status, the worst status reported by any node wins
+var dtStatusRank = map[string]int{"ready": 0, "unknown": 1, "unready": 2}
+
+// mergeDts merges DTs reported by all nodes, ordered by DT ID. Ownership is
+// taken from the first node in results reporting the DT.
+func mergeDts(results []dtNodeResult) []ecs.DtEntry {
+	merged := make(map[string]*ecs.DtEntry)
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		for _, entry := range r.infos.DtEntries {
+			if _, ok := merged[entry.DtID]; !ok {
+				e := entry
+				merged[entry.DtID] = &e
+			}
+		}
+	}
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		for _, bad := range r.inits.DtEntries {
+			if entry, ok := merged[bad.DtID]; ok && dtStatusRank[bad.DtStatus] > dtStatusRank[entry.DtStatus] {
+				entry.DtError, entry.DtStatus, entry.DtReady, entry.DtDown = bad.DtError, bad.DtStatus, bad.DtReady, bad.DtDown
+			}
+		}
+	}
+	result := make([]ecs.DtEntry, 0, len(merged))
+	for _, entry := range merged {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DtID < result[j].DtID })
+	return result
+}
+
+type dtCounts struct {
+	count, unready, unknown int
+}
+
+func (c *dtCounts) add(entry ecs.DtEntry) {
+	c.count++
+	switch entry.DtStatus {
+	case "unready":
+		c.unready++
+	case "unknown":
+		c.unknown++
+	}
+}
+
+// dtImbalance is the coefficient of variation of DTs owned per node, 0 if
+// DTs are evenly owned by all nodes
+func dtImbalance(owned map[string]int) float64 {
+	if len(owned) == 0 {
+		return 0
+	}
+	var sum, squares float64
+	for _, n := range owned {
+		sum += float64(n)
+	}
+	mean := sum / float64(len(owned))
+	if mean == 0 {
+		return 0
+	}
+	for _, n := range owned {
+		squares += (float64(n) - mean) * (float64(n) - mean)
+	}
+	return math.Sqrt(squares/float64(len(owned))) / mean
+}
+
+// dtViewEvents builds dtbalance events per owner, type and level, dtnode
+// events per node and the dtsummary event of a VDC, along with DT counts of
+// the summary. Fields common to all events are not added.
+func dtViewEvents(entries []ecs.DtEntry, results []dtNodeResult) (total dtCounts, summary common.MapStr, balance, nodes []common.MapStr) {
+	type key struct{ owner, typ, level string }
+	groups := make(map[key]*dtCounts)
+	owned := make(map[string]int)
+	for _, r := range results {
+		owned[r.ip] = 0
+	}
+	for _, entry := range entries {
+		k := key{entry.DtOwnerIP, entry.DtType, entry.DtLevel}
+		if groups[k] == nil {
+			groups[k] = &dtCounts{}
+		}
+		groups[k].add(entry)
+		total.add(entry)
+		owned[entry.DtOwnerIP]++
+	}
+
+	keys := make([]key, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.owner != b.owner {
+			return a.owner < b.owner
+		}
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		return a.level < b.level
+	})
+	for _, k := range keys {
+		c := groups[k]
+		balance = append(balance, common.MapStr{
+			"dt-owner-ip":      k.owner,
+			"dt-type":          k.typ,
+			"dt-level":         k.level,
+			"dt-count":         c.count,
+			"dt-unready-count": c.unready,
+			"dt-unknown-count": c.unknown,
+		})
+	}
+
+	var available, owners int
+	for _, n := range owned {
+		if n > 0 {
+			owners++
+		}
+	}
+	for _, r := range results {
+		if r.err == nil {
+			available++
+		}
+		nodes = append(nodes, common.MapStr{
+			"dt-diagnostic-available": r.err == nil,
+			"dt-owned-count":          owned[r.ip],
+		})
+	}
+
+	summary = common.MapStr{
+		"dt-count":           total.count,
+		"dt-unready-count":   total.unready,
+		"dt-unknown-count":   total.unknown,
+		"dt-owner-count":     owners,
+		"dt-imbalance":       dtImbalance(owned),
+		"dt-nodes-total":     len(results),
+		"dt-nodes-available": available,
+	}
+	return total, summary, balance, nodes
+}
+
+// generateDtClusterView queries DTs from every node of every VDC at once, and
+// publishes merged DTs along with balance, node and summary events per VDC
+func generateDtClusterView(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
+	done <-chan struct{}, out chan<- common.MapStr) (bool, error) {
+
+	var (
+		vdcs  []*Vdc
+		ips   []string
+		count []int
+	)
+	for _, vdc := range config.Vdcs {
+		nodes := vdc.Nodes()
+		for _, node := range nodes {
+			ips = append(ips, node.IP)
+		}
+		vdcs, count = append(vdcs, vdc), append(count, len(nodes))
+	}
+	all := queryDtNodes(client, ips)
+
+	for i, vdc := range vdcs {
+		results := all[:count[i]]
+		all = all[count[i]:]
+		for _, r := range results {
+			if r.err != nil {
+				logp.Warn("%s: diagnostic port of %s: %v", cmd.Type, r.ip, r.err)
+			}
+		}
+
+		entries := mergeDts(results)
+		for _, entry := range entries {
+			if !writeEvents(done, out, dtEvents(cmd, config, entry, vdc.ConfigName, entry.DtOwnerIP)) {
+				return false, nil
+			}
+		}
+
+		total, summary, balance, nodes := dtViewEvents(entries, results)
+		cmd.Health.ObserveDts(config.CustomerName, vdc.ConfigName, total.unready+total.unknown)
+		var events []common.MapStr
+		for _, e := range balance {
+			addCommonFields(e, config, vdc.ConfigName, e["dt-owner-ip"].(string), "dtbalance")
+			events = append(events, e)
+		}
+		for i, e := range nodes {
+			addCommonFields(e, config, vdc.ConfigName, results[i].ip, "dtnode")
+			events = append(events, e)
+		}
+		addCommonFields(summary, config, vdc.ConfigName, "", "dtsummary")
+		events = append(events, summary)
+		if !writeEvents(done, out, events) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package beater
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+func dtEntry(id, owner, typ, level, status string) ecs.DtEntry {
+	return ecs.DtEntry{DtID: id, DtOwnerIP: owner, DtType: typ, DtLevel: level, DtStatus: status}
+}
+
+// TestDtClusterView ...
+func TestDtClusterView(t *testing.T) {
+	results := []dtNodeResult{
+		{
+			ip: "1.1.1.1",
+			infos: &ecs.DtInfos{DtEntries: []ecs.DtEntry{
+				dtEntry("dt2", "1.1.1.1", "OB", "0", "ready"),
+				dtEntry("dt1", "1.1.1.1", "OB", "0", "ready"),
+				dtEntry("dt3", "2.2.2.2", "LS", "1", "ready"),
+			}},
+			inits: &ecs.DtInfos{DtEntries: []ecs.DtEntry{dtEntry("dt2", "", "", "", "unknown")}},
+		},
+		{
+			ip: "2.2.2.2",
+			infos: &ecs.DtInfos{DtEntries: []ecs.DtEntry{
+				dtEntry("dt3", "2.2.2.2", "LS", "1", "ready"),
+				dtEntry("dt4", "2.2.2.2", "LS", "1", "ready"),
+			}},
+			inits: &ecs.DtInfos{DtEntries: []ecs.DtEntry{dtEntry("dt2", "", "", "", "unready")}},
+		},
+		{ip: "3.3.3.3", err: errors.New("connection refused")},
+	}
+
+	entries := mergeDts(results)
+	ecs.AssertEqualFatal(t, 4, len(entries), "")
+	ecs.AssertEqual(t, "dt1", entries[0].DtID, "")
+	ecs.AssertEqual(t, "unready", entries[1].DtStatus, "worst status wins")
+
+	total, summary, balance, nodes := dtViewEvents(entries, results)
+	ecs.AssertEqual(t, dtCounts{count: 4, unready: 1}, total, "")
+	ecs.AssertEqual(t, 4, summary["dt-count"], "")
+	ecs.AssertEqual(t, 1, summary["dt-unready-count"], "")
+	ecs.AssertEqual(t, 0, summary["dt-unknown-count"], "")
+	ecs.AssertEqual(t, 2, summary["dt-owner-count"], "")
+	ecs.AssertEqual(t, 3, summary["dt-nodes-total"], "")
+	ecs.AssertEqual(t, 2, summary["dt-nodes-available"], "")
+	// 2, 2 and 0 DTs per node
+	ecs.AssertEqual(t, true, summary["dt-imbalance"].(float64) > 0.7, "")
+
+	ecs.AssertEqualFatal(t, 2, len(balance), "")
+	ecs.AssertEqual(t, "1.1.1.1", balance[0]["dt-owner-ip"], "")
+	ecs.AssertEqual(t, 2, balance[0]["dt-count"], "")
+	ecs.AssertEqual(t, 1, balance[0]["dt-unready-count"], "")
+	ecs.AssertEqual(t, "LS", balance[1]["dt-type"], "")
+
+	ecs.AssertEqualFatal(t, 3, len(nodes), "")
+	ecs.AssertEqual(t, false, nodes[2]["dt-diagnostic-available"], "")
+	ecs.AssertEqual(t, 0, nodes[2]["dt-owned-count"], "")
+
+	ecs.AssertEqual(t, 0.0, dtImbalance(map[string]int{"a": 3, "b": 3}), "")
+
+	_, declared, err := templateFields()
+	ecs.AssertEqualFatal(t, nil, err, "")
+	for _, event := range append(append(balance, nodes...), summary) {
+		for _, name := range flattenKeys("", event) {
+			ecs.AssertEqual(t, true, inTemplate(declared, name), name)
+		}
+	}
+}
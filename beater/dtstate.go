@@ -0,0 +1,147 @@
+package beater
+
+import (
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+const dtStatesKey = "dtstates"
+
+// maxDtStateAge is how long states of DTs no longer reported are kept
+const maxDtStateAge = 24 * time.Hour
+
+type dtState struct {
+	Status string    `json:"status"`
+	Error  string    `json:"error"`
+	Since  time.Time `json:"since"`
+	// UnreadySince is when the DT was last ready, zero while it's ready
+	UnreadySince time.Time `json:"unready_since"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// DtStates tracks status of every DT across dtinfo polls, to tell how long
+// a DT has been unready and to publish its state transitions
+type DtStates struct {
+	mutex    sync.Mutex
+	registry *Registry
+	states   map[string]*dtState
+	// dirty is set once a DT is added, changes or is dropped until saved,
+	// LastSeen alone changing every poll doesn't count
+	dirty bool
+}
+
+// NewDtStates loads states of DTs from registry
+func NewDtStates(registry *Registry) *DtStates {
+	s := &DtStates{registry: registry, states: make(map[string]*dtState)}
+	registry.Get(dtStatesKey, &s.states)
+	return s
+}
+
+// Observe records status of entry of customer at now. It returns when the DT
+// stopped being ready, zero if it's ready, and the transition event without
+// common fields if status changed since the previous poll.
+func (s *DtStates) Observe(customer string, entry ecs.DtEntry, now time.Time) (time.Time, common.MapStr) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := customer + "/" + entry.DtID
+	state, ok := s.states[key]
+	if !ok {
+		// DTs first seen unready are considered unready since now
+		state = &dtState{Status: entry.DtStatus, Error: entry.DtError, Since: now}
+		if entry.DtStatus != "ready" {
+			state.UnreadySince = now
+		}
+		state.LastSeen = now
+		s.states[key] = state
+		s.dirty = true
+		return state.UnreadySince, nil
+	}
+	state.LastSeen = now
+	if state.Status == entry.DtStatus {
+		if state.Error != entry.DtError {
+			state.Error, s.dirty = entry.DtError, true
+		}
+		return state.UnreadySince, nil
+	}
+
+	transition := common.MapStr{
+		"dt-id":               entry.DtID,
+		"dt-type":             entry.DtType,
+		"dt-level":            entry.DtLevel,
+		"dt-owner-ip":         entry.DtOwnerIP,
+		"dt-status":           entry.DtStatus,
+		"dt-error":            entry.DtError,
+		"dt-previous-status":  state.Status,
+		"dt-previous-error":   state.Error,
+		"dt-previous-since":   common.Time(state.Since),
+		"dt-previous-seconds": now.Sub(state.Since).Seconds(),
+	}
+	switch {
+	case entry.DtStatus == "ready":
+		transition["dt-unready-seconds"] = now.Sub(state.UnreadySince).Seconds()
+		state.UnreadySince = time.Time{}
+	case state.Status == "ready":
+		state.UnreadySince = now
+	}
+	state.Status, state.Error, state.Since = entry.DtStatus, entry.DtError, now
+	s.dirty = true
+	return state.UnreadySince, transition
+}
+
+// Save persists states of DTs if any changed, it's called once per poll.
+// States of DTs not reported for maxDtStateAge are dropped.
+func (s *DtStates) Save() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var latest time.Time
+	for _, state := range s.states {
+		if state.LastSeen.After(latest) {
+			latest = state.LastSeen
+		}
+	}
+	for k, state := range s.states {
+		if latest.Sub(state.LastSeen) > maxDtStateAge {
+			delete(s.states, k)
+			s.dirty = true
+		}
+	}
+	if !s.dirty {
+		return
+	}
+	if err := s.registry.Set(dtStatesKey, s.states); err != nil {
+		logp.Err("failed to save DT states: %v", err)
+		return
+	}
+	s.dirty = false
+}
+
+// dtEvents builds the snapshot event of entry, preceded by its transition
+// event if status of the DT changed
+func dtEvents(cmd *Command, config *ClusterConfig, entry ecs.DtEntry, vdc, node string) []common.MapStr {
+	var (
+		since      time.Time
+		transition common.MapStr
+	)
+	if cmd.DtStates != nil {
+		since, transition = cmd.DtStates.Observe(config.CustomerName, entry, time.Now())
+	}
+	// DTs have no time series
+	events := buildEvent(cmd, config, struct2Map(entry), vdc, node)
+	if !since.IsZero() {
+		for _, e := range events {
+			e["dt-unready-since"] = common.Time(since)
+		}
+	}
+	if transition != nil {
+		addCommonFields(transition, config, vdc, node, "dttransition")
+		events = append([]common.MapStr{transition}, events...)
+	}
+	return events
+}
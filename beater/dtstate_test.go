@@ -0,0 +1,59 @@
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestDtStates ...
+func TestDtStates(t *testing.T) {
+	registry, _ := NewRegistry("")
+	s := NewDtStates(registry)
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	since, transition := s.Observe("c1", dtEntry("dt1", "1.1.1.1", "OB", "0", "ready"), now)
+	ecs.AssertEqual(t, true, since.IsZero(), "")
+	ecs.AssertEqual(t, common.MapStr(nil), transition, "")
+
+	// ready to unready
+	entry := dtEntry("dt1", "1.1.1.1", "OB", "0", "unready")
+	entry.DtError = "ERROR_TIMEOUT"
+	since, transition = s.Observe("c1", entry, now.Add(time.Hour))
+	ecs.AssertEqual(t, now.Add(time.Hour), since, "")
+	ecs.AssertEqual(t, "ready", transition["dt-previous-status"], "")
+	ecs.AssertEqual(t, "unready", transition["dt-status"], "")
+	ecs.AssertEqual(t, 3600.0, transition["dt-previous-seconds"], "")
+
+	// unready to unknown keeps unready since, and survives restarts
+	s.Save()
+	s = NewDtStates(registry)
+	since, transition = s.Observe("c1", dtEntry("dt1", "1.1.1.1", "OB", "0", "unknown"), now.Add(2*time.Hour))
+	ecs.AssertEqual(t, true, since.Equal(now.Add(time.Hour)), "")
+	ecs.AssertEqual(t, "ERROR_TIMEOUT", transition["dt-previous-error"], "")
+
+	// saved once changed only
+	s.Save()
+	registry.Set(dtStatesKey, map[string]*dtState{})
+	since, transition = s.Observe("c1", dtEntry("dt1", "1.1.1.1", "OB", "0", "unknown"), now.Add(3*time.Hour))
+	ecs.AssertEqual(t, common.MapStr(nil), transition, "")
+	s.Save()
+	saved := make(map[string]*dtState)
+	registry.Get(dtStatesKey, &saved)
+	ecs.AssertEqual(t, 0, len(saved), "")
+
+	// recovery
+	since, transition = s.Observe("c1", dtEntry("dt1", "1.1.1.1", "OB", "0", "ready"), now.Add(4*time.Hour))
+	ecs.AssertEqual(t, true, since.IsZero(), "")
+	ecs.AssertEqual(t, 7200.0, transition["dt-previous-seconds"], "")
+	ecs.AssertEqual(t, 10800.0, transition["dt-unready-seconds"], "")
+
+	// DTs first seen unready
+	since, _ = s.Observe("c1", dtEntry("dt2", "1.1.1.1", "OB", "0", "unready"), now)
+	ecs.AssertEqual(t, now, since, "")
+	s.Save()
+	registry.Get(dtStatesKey, &saved)
+	ecs.AssertEqual(t, 2, len(saved), "")
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/paths"
 	"github.com/elastic/beats/libbeat/publisher"
 
 	"github.com/yangb8/ecsbeat/config"
@@ -28,7 +29,19 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 		return nil, fmt.Errorf("Error reading config file: %v", err)
 	}
 
-	ec := NewEcsClusters(config)
+	if config.Layout != "legacy" && config.Layout != "ecs" {
+		return nil, fmt.Errorf("Error reading config file: unknown layout %q", config.Layout)
+	}
+
+	registry, err := NewRegistry(paths.Resolve(paths.Data, config.RegistryFile))
+	if err != nil {
+		return nil, fmt.Errorf("Error loading registry file: %v", err)
+	}
+
+	ec, err := NewEcsClusters(config, registry)
+	if err != nil {
+		return nil, fmt.Errorf("Error setting up ECS clusters: %v", err)
+	}
 
 	bt := &Ecsbeat{
 		done:        make(chan struct{}),
@@ -52,11 +65,15 @@ func (bt *Ecsbeat) Run(b *beat.Beat) error {
 	logp.Info("ecsbeat is running! Hit CTRL-C to stop it.")
 
 	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		StartRefreshConfig(bt.ecsClusters, bt.done)
-	}()
+	// config is refreshed until done is closed, which never happens if once
+	// is set
+	if !bt.config.Once {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			StartRefreshConfig(bt.ecsClusters, bt.done)
+		}()
+	}
 
 	bt.client = b.Publisher.Connect()
 
@@ -64,6 +81,26 @@ func (bt *Ecsbeat) Run(b *beat.Beat) error {
 	for _, w := range bt.workers {
 		cs = append(cs, w.Start(bt.done, bt.config.Once))
 	}
+	cs = append(cs, bt.ecsClusters.Changes.Start(bt.done))
+	if bt.config.Once {
+		// changes of disks are observed by workers until they stop
+		go func() {
+			for _, w := range bt.workers {
+				<-w.stopped
+			}
+			bt.ecsClusters.Changes.Close()
+		}()
+	}
+	// alerts raised by rules are notified along with ECS alerts
+	bt.ecsClusters.Notifier.Start()
+	for i, c := range cs {
+		cs[i] = bt.ecsClusters.Notifier.Process(bt.done, bt.ecsClusters.Rules.Process(bt.done, c))
+		cs[i] = bt.ecsClusters.Router.Process(bt.done, cs[i])
+		// rules and notifications see events of the legacy layout
+		if bt.config.Layout == "ecs" {
+			cs[i] = filterEvents(bt.done, cs[i], ecsLayout)
+		}
+	}
 
 	wg.Add(1)
 	go func() {
@@ -72,6 +109,8 @@ func (bt *Ecsbeat) Run(b *beat.Beat) error {
 	}()
 	// Wait for StartRefreshConfig exits and PublishChannels to stop publishing
 	wg.Wait()
+	bt.ecsClusters.Rules.Save()
+	bt.ecsClusters.Notifier.Close()
 
 	for _, ecs := range bt.ecsClusters.EcsSlice {
 		ecs.Client.Close()
@@ -1,9 +1,16 @@
 package beater
 
 import (
+	"fmt"
+	"path"
 	"sync"
 	"time"
 
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/paths"
+	"github.com/elastic/beats/libbeat/processors"
+	// registers drop_event, drop_fields and include_fields
+	_ "github.com/elastic/beats/libbeat/processors/actions"
 	"github.com/yangb8/ecsbeat/config"
 	"github.com/yangb8/ecsbeat/ecs"
 )
@@ -14,6 +21,7 @@ type EcsCluster struct {
 	CfgRefresh   time.Duration
 	Config       *ClusterConfig
 	Client       *ecs.MgmtClient
+	Changes      *ChangeDetector
 }
 
 // Refresh ...
@@ -21,24 +29,71 @@ func (e *EcsCluster) Refresh(addnode bool) {
 	for vname, ventry := range e.Config.Vdcs {
 		if vdcResp, err := ecs.GetLocalVDC(e.Client, vname); err == nil {
 			ventry.Update(vname, vdcResp.ID, vdcResp.Name)
+			e.Changes.ObserveVdc(e.Config, vname, vdcResp.Name)
 		}
 		if nodesResp, err := ecs.GetNodes(e.Client, vname); err == nil {
+			nodes := make(map[string]nodeSnapshot, len(nodesResp.Node))
+			ips := make(map[string]bool, len(nodesResp.Node))
 			for _, n := range nodesResp.Node {
-				if _, ok := ventry.NodeInfo[n.IP]; ok {
-					ventry.NodeInfo[n.IP].Update(n.NodeID, n.IP, n.Nodename, n.Version)
+				nodes[n.NodeID] = nodeSnapshot{IP: n.IP, Name: n.Nodename, Version: n.Version, Status: n.Status}
+				ips[n.IP] = true
+				node, ok := ventry.Node(n.IP)
+				if ok {
+					node.Update(n.NodeID, n.IP, n.Nodename, n.Version)
 				} else if addnode {
-					ventry.NodeInfo[n.IP] = &Node{ID: n.NodeID, IP: n.IP, Name: n.Nodename, Version: n.Version}
+					node, ok = &Node{ID: n.NodeID, IP: n.IP, Name: n.Nodename, Version: n.Version}, true
+					ventry.AddNode(node)
 				}
+				if ok {
+					node.UpdateLocation(n.RackID, n.Status)
+				}
+			}
+			// an empty response is taken as a glitch rather than all nodes
+			// removed, nodes removed from ECS are neither queried nor in
+			// inventory anymore
+			if len(ips) > 0 {
+				for _, ip := range ventry.RemoveNodes(ips) {
+					logp.Info("%s: node %s of %s is removed", e.CustomerName, ip, vname)
+				}
+				e.Changes.ObserveNodes(e.Config, vname, nodes)
 			}
 		}
+		e.refreshStoragepools(vname, ventry)
 		// TODO log error
 	}
+	e.Config.Namespaces.Refresh(e.Client, e.Config.Vdcs)
+}
+
+// refreshStoragepools updates storage pools of ventry and storage pool of its nodes
+func (e *EcsCluster) refreshStoragepools(vname string, ventry *Vdc) {
+	pools, err := ecs.GetStoragePool(e.Client, vname)
+	if err != nil {
+		logp.Err("storage pools of %s: %v", vname, err)
+		return
+	}
+	var ids, names []string
+	for _, p := range pools.Varray {
+		ids, names = append(ids, p.ID), append(names, p.Name)
+		members, err := ecs.GetStoragePoolNodes(e.Client, vname, p.ID)
+		if err != nil {
+			logp.Err("nodes of storage pool %s: %v", p.ID, err)
+			continue
+		}
+		for _, m := range members.Embedded.Instances {
+			if node, ok := ventry.Node(ventry.GetIpById(m.ID)); ok {
+				node.UpdateStoragepool(p.ID, p.Name)
+			}
+		}
+	}
+	ventry.UpdateStoragepools(ids, names)
 }
 
 // NewEcsCluster ...
 func NewEcsCluster(c *config.Customer) *EcsCluster {
 	return &EcsCluster{
-		Config: GetClusterConfig(c),
+		CustomerName: c.CustomerName,
+		CfgRefresh:   c.CfgRefreshInterval,
+		Config:       GetClusterConfig(c),
 		Client: ecs.NewMgmtClient(
 			"ecs",
 			c.Username,
@@ -53,11 +108,44 @@ func NewEcsCluster(c *config.Customer) *EcsCluster {
 type EcsClusters struct {
 	Cmds     []*Command
 	EcsSlice []*EcsCluster
+	Registry *Registry
+	Changes  *ChangeDetector
+	Rules    *Rules
+	Notifier *Notifier
+	Router   *Router
 }
 
 // NewEcsClusters ...
-func NewEcsClusters(config config.Config) *EcsClusters {
-	ec := EcsClusters{}
+func NewEcsClusters(config config.Config, registry *Registry) (*EcsClusters, error) {
+	ec := EcsClusters{Registry: registry, Changes: NewChangeDetector(registry)}
+	var err error
+	if ec.Rules, err = NewRules(config.Rules, registry); err != nil {
+		return nil, err
+	}
+	if ec.Notifier, err = NewNotifier(config, registry); err != nil {
+		return nil, err
+	}
+	if ec.Router, err = NewRouter(config); err != nil {
+		return nil, err
+	}
+	// one pool bounds queries of all commands
+	pool, err := NewPool(config)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := NewCheckpoints(registry)
+	dedup := NewDedup(registry, config.DedupSize)
+	forecaster := NewForecaster(registry, config.Forecast)
+	counters := NewCounters(registry)
+	dtStates := NewDtStates(registry)
+	// signals of every command are scored by health commands
+	var health *Health
+	for _, c := range config.Commands {
+		if c.Enabled && c.Level == "health" {
+			health = NewHealth(config.Health)
+			break
+		}
+	}
 	for _, c := range config.Commands {
 		if c.Enabled {
 			interval := config.Period
@@ -65,15 +153,114 @@ func NewEcsClusters(config config.Config) *EcsClusters {
 			if c.Interval > 0 {
 				interval = c.Interval
 			}
-			ec.Cmds = append(ec.Cmds, &Command{c.URI, c.Type, c.Level, interval})
+			mapping, err := NewMapping(c.Mapping)
+			if err != nil {
+				return nil, fmt.Errorf("%s mapping: %v", c.Type, err)
+			}
+			series, err := NewSeriesMode(c.Series)
+			if err != nil {
+				return nil, fmt.Errorf("%s series: %v", c.Type, err)
+			}
+			timestamp, err := NewTimestampSource(c.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("%s timestamp: %v", c.Type, err)
+			}
+			maxLookback := c.MaxLookback
+			if maxLookback == 0 {
+				maxLookback = DefaultMaxLookback
+			}
+			units := make(map[string]string)
+			for _, u := range c.Units {
+				if canonicalOf(u.Unit) == "" {
+					return nil, fmt.Errorf("%s units: unknown unit %q of %s", c.Type, u.Unit, u.Field)
+				}
+				units[u.Field] = u.Unit
+			}
+			counterFields, counterGap := CounterTables[c.Type], DefaultCounterGap
+			if c.Counters != nil {
+				if c.Counters.Fields != nil {
+					counterFields = c.Counters.Fields
+				}
+				if c.Counters.MaxGap > 0 {
+					counterGap = c.Counters.MaxGap
+				}
+			}
+			for _, f := range counterFields {
+				if _, err := path.Match(f, ""); err != nil {
+					return nil, fmt.Errorf("%s counters: %s: %v", c.Type, f, err)
+				}
+			}
+			cmd := &Command{
+				URI:       c.URI,
+				Type:      c.Type,
+				Level:     c.Level,
+				Interval:  interval,
+				Mapping:   mapping,
+				Series:    series,
+				Timestamp: timestamp,
+				// one checkpoint registry is shared by all commands
+				Checkpoints:  checkpoints,
+				MaxLookback:  maxLookback,
+				Units:        units,
+				KeepOriginal: c.KeepOriginal,
+				RouteToNode:  c.RouteToNode,
+				ClusterView:  c.ClusterView,
+				Pool:         pool,
+				Health:       health,
+			}
+			if isDeduplicated(cmd) {
+				cmd.Dedup = dedup
+			}
+			if cmd.Type == "storagepools" {
+				cmd.Forecaster = forecaster
+			}
+			if len(counterFields) > 0 {
+				cmd.Counters, cmd.CounterFields, cmd.CounterGap = counters, counterFields, counterGap
+			}
+			if cmd.Type == "dtinfo" {
+				cmd.DtStates = dtStates
+			}
+			if cmd.Type == "disks" {
+				cmd.Changes = ec.Changes
+			}
+			if len(c.Processors) > 0 {
+				procs, err := processors.New(c.Processors)
+				if err != nil {
+					return nil, fmt.Errorf("%s processors: %v", c.Type, err)
+				}
+				cmd.Processors = procs
+			}
+			// the bucket command copies cmd, so it's built once every field is set
+			if _, ok := bucketTypes[cmd.Type]; ok {
+				if cmd.Billing, err = NewBilling(cmd, c); err != nil {
+					return nil, fmt.Errorf("%s: %v", c.Type, err)
+				}
+			}
+			ec.Cmds = append(ec.Cmds, cmd)
 		}
 	}
 
+	var billing bool
+	for _, cmd := range ec.Cmds {
+		billing = billing || cmd.Billing != nil
+	}
 	for _, customer := range config.Customers {
-		ec.EcsSlice = append(ec.EcsSlice, NewEcsCluster(customer))
+		cluster := NewEcsCluster(customer)
+		cluster.Changes = ec.Changes
+		if billing {
+			var owners map[string]NamespaceOwner
+			if customer.NamespaceMapping != "" {
+				var err error
+				if owners, err = LoadNamespaceMapping(paths.Resolve(paths.Config, customer.NamespaceMapping)); err != nil {
+					return nil, fmt.Errorf("%s namespace mapping: %v", customer.CustomerName, err)
+				}
+			}
+			cluster.Config.Namespaces = NewNamespaceCache(owners)
+		}
+		ec.EcsSlice = append(ec.EcsSlice, cluster)
 	}
 
-	return &ec
+	return &ec, nil
 }
 
 // Refresh ...
@@ -0,0 +1,206 @@
+package beater
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// fakeEcs serves /login and responses of handler to customer with one VDC of
+// one node, named VDC1
+func fakeEcs(t *testing.T, customer string, refresh time.Duration, handler http.HandlerFunc) (*EcsCluster, func()) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			w.Header().Set("X-Sds-Auth-Token", "token")
+		case "/logout":
+		default:
+			handler(w, r)
+		}
+	}))
+	host := strings.TrimPrefix(server.URL, "https://")
+	registry, _ := NewRegistry("")
+	cluster := &EcsCluster{
+		CustomerName: customer,
+		CfgRefresh:   refresh,
+		Config: &ClusterConfig{
+			CustomerName: customer,
+			CfgRefresh:   refresh,
+			Vdcs:         map[string]*Vdc{"VDC1": {ConfigName: "VDC1", NodeInfo: make(map[string]*Node)}},
+		},
+		Client: ecs.NewMgmtClient("ecs", "user", "password",
+			ecs.NewEcs(map[string]*ecs.Vdc{"VDC1": ecs.NewVdc("VDC1", []string{host})}), 5*time.Second, 0),
+		Changes: NewChangeDetector(registry),
+	}
+	return cluster, func() {
+		cluster.Client.Close()
+		server.Close()
+	}
+}
+
+// TestNewEcsCluster ...
+func TestNewEcsCluster(t *testing.T) {
+	cluster := NewEcsCluster(&config.Customer{CustomerName: "c1", CfgRefreshInterval: time.Hour})
+	ecs.AssertEqual(t, "c1", cluster.CustomerName, "")
+	ecs.AssertEqual(t, time.Hour, cluster.CfgRefresh, "config is refreshed periodically")
+}
+
+// TestNamespacesBetweenPolls ...
+func TestNamespacesBetweenPolls(t *testing.T) {
+	var (
+		mutex      sync.Mutex
+		namespaces = []string{"ns1"}
+		billed     [][]string
+	)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.URL.Path == "/object/namespaces.json":
+			var list []map[string]string
+			for _, id := range namespaces {
+				list = append(list, map[string]string{"id": id})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"namespace": list})
+		case strings.HasPrefix(r.URL.Path, "/object/namespaces/namespace/"):
+			id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/object/namespaces/namespace/"), ".json")
+			json.NewEncoder(w).Encode(map[string]string{"id": id, "name": id})
+		case r.URL.Path == "/object/billing/namespace/info.json":
+			var body struct {
+				ID []string `json:"id"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			billed = append(billed, body.ID)
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+
+	for _, refresh := range []time.Duration{time.Hour, 0} {
+		mutex.Lock()
+		namespaces, billed = []string{"ns1"}, nil
+		mutex.Unlock()
+		cluster, stop := fakeEcs(t, "c1", refresh, handler)
+		cluster.Config.Namespaces = NewNamespaceCache(nil)
+		cluster.Refresh(true)
+
+		cmd := &Command{URI: "/object/billing/namespace/info.json", Type: "nsbilling", Level: "system", Mapping: &Mapping{}}
+		var err error
+		cmd.Billing, err = NewBilling(cmd, &config.Command{})
+		ecs.AssertEqualFatal(t, nil, err, "")
+		poll := func() {
+			out := make(chan common.MapStr, 10)
+			torun, err := GenerateEvents(cmd, cluster.Config, cluster.Client, make(chan struct{}), out)
+			ecs.AssertEqual(t, true, torun, "")
+			ecs.AssertEqual(t, nil, err, "")
+		}
+		poll()
+		mutex.Lock()
+		namespaces = []string{"ns1", "ns2"}
+		mutex.Unlock()
+		if refresh > 0 {
+			// listed again once config is refreshed
+			poll()
+			cluster.Refresh(false)
+		}
+		poll()
+		stop()
+
+		mutex.Lock()
+		ecs.AssertEqual(t, []string{"ns1"}, billed[0], "")
+		ecs.AssertEqual(t, []string{"ns1", "ns2"}, billed[len(billed)-1], "")
+		if refresh > 0 {
+			ecs.AssertEqual(t, []string{"ns1"}, billed[1], "cached until refreshed")
+		}
+		mutex.Unlock()
+	}
+}
+
+// TestRefreshChanges ...
+func TestRefreshChanges(t *testing.T) {
+	var mutex sync.Mutex
+	name := "vdc1"
+	nodes := []map[string]string{{"ip": "1.1.1.1", "nodeid": "n1", "nodename": "node1", "version": "3.0", "status": "Good"}}
+	cluster, stop := fakeEcs(t, "c1", time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/object/vdcs/vdc/local.json":
+			json.NewEncoder(w).Encode(map[string]string{"id": "vdc-id", "name": name})
+		case "/vdc/nodes.json":
+			json.NewEncoder(w).Encode(map[string]interface{}{"node": nodes})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer stop()
+
+	cluster.Refresh(true)
+	ecs.AssertEqual(t, 0, len(pendingChanges(cluster.Changes)), "")
+
+	mutex.Lock()
+	name = "vdc1-renamed"
+	nodes[0]["status"] = "Bad"
+	nodes = append(nodes, map[string]string{"ip": "2.2.2.2", "nodeid": "n2", "nodename": "node2", "version": "3.0", "status": "Good"})
+	mutex.Unlock()
+	cluster.Refresh(false)
+
+	types := make(map[string]bool)
+	for _, event := range pendingChanges(cluster.Changes) {
+		types[event["change-type"].(string)] = true
+	}
+	ecs.AssertEqual(t, map[string]bool{"vdc_renamed": true, "node_status_changed": true, "node_added": true}, types, "")
+	_, status, _, _ := cluster.Config.Vdcs["VDC1"].NodeInfo["1.1.1.1"].GetLocation()
+	ecs.AssertEqual(t, "Bad", status, "")
+	ecs.AssertEqual(t, 1, len(cluster.Config.Vdcs["VDC1"].Nodes()), "nodes are only added on startup")
+
+	// nodes no longer returned by ECS are dropped
+	mutex.Lock()
+	nodes = nodes[1:]
+	mutex.Unlock()
+	cluster.Refresh(false)
+	pendingChanges(cluster.Changes)
+	_, ok := cluster.Config.Vdcs["VDC1"].Node("1.1.1.1")
+	ecs.AssertEqual(t, false, ok, "")
+	ecs.AssertEqual(t, 0, len(cluster.Config.Vdcs["VDC1"].Nodes()), "")
+}
+
+// TestRefreshEmptyNodes ...
+func TestRefreshEmptyNodes(t *testing.T) {
+	var mutex sync.Mutex
+	nodes := []map[string]string{{"ip": "1.1.1.1", "nodeid": "n1", "nodename": "node1", "version": "3.0", "status": "Good"}}
+	cluster, stop := fakeEcs(t, "c1", time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/vdc/nodes.json":
+			json.NewEncoder(w).Encode(map[string]interface{}{"node": nodes})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer stop()
+
+	cluster.Refresh(true)
+	mutex.Lock()
+	nodes = nil
+	mutex.Unlock()
+	cluster.Refresh(false)
+	ecs.AssertEqual(t, 0, len(pendingChanges(cluster.Changes)), "no node_removed")
+	ecs.AssertEqual(t, 1, len(cluster.Config.Vdcs["VDC1"].Nodes()), "")
+
+	mutex.Lock()
+	nodes = []map[string]string{{"ip": "1.1.1.1", "nodeid": "n1", "nodename": "node1", "version": "3.0", "status": "Good"}}
+	mutex.Unlock()
+	cluster.Refresh(false)
+	ecs.AssertEqual(t, 0, len(pendingChanges(cluster.Changes)), "no node_added")
+}
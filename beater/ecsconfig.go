@@ -1,6 +1,7 @@
 package beater
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -31,7 +32,7 @@ func GetClusterConfig(c *config.Customer) *ClusterConfig {
 			NodeInfo: make(map[string]*Node),
 		}
 	}
-	return &ClusterConfig{c.CustomerName, c.CfgRefreshInterval, Vdcs}
+	return &ClusterConfig{CustomerName: c.CustomerName, CfgRefresh: c.CfgRefreshInterval, Vdcs: Vdcs}
 }
 
 // ClusterConfig ...
@@ -39,6 +40,19 @@ type ClusterConfig struct {
 	CustomerName string
 	CfgRefresh   time.Duration
 	Vdcs         map[string]*Vdc
+	// Namespaces is set only if billing commands are enabled
+	Namespaces *NamespaceCache
+}
+
+// VdcOfNode returns config name of the VDC node ip belongs to, or "" if ip is
+// unknown
+func (c *ClusterConfig) VdcOfNode(ip string) string {
+	for vname, vdc := range c.Vdcs {
+		if _, ok := vdc.Node(ip); ok {
+			return vname
+		}
+	}
+	return ""
 }
 
 // Vdc ...
@@ -46,8 +60,8 @@ type Vdc struct {
 	ConfigName       string           `json:"ecs-config-name"`
 	ID               string           `json:"ecs-vdc-id"`
 	Name             string           `json:"ecs-vdc-name"`
-	StoragepoolID    string           `json:"ecs-storagepool-id"`
-	StoragepoolName  string           `json:"ecs-storagepool-name"`
+	StoragepoolIDs   []string         `json:"ecs-storagepool-id"`
+	StoragepoolNames []string         `json:"ecs-storagepool-name"`
 	LastUpdatedDate  string           `json:"lastUpdatedDate"`
 	ManualUpdateOnly bool             `json:"manualUpdateOnly"`
 	NodeInfo         map[string]*Node `json:"nodeInfo"`
@@ -70,6 +84,63 @@ func (v *Vdc) Get() (string, string, string) {
 	return v.ConfigName, v.ID, v.Name
 }
 
+// UpdateStoragepools sets IDs and names of storage pools of the VDC
+func (v *Vdc) UpdateStoragepools(ids, names []string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.StoragepoolIDs = ids
+	v.StoragepoolNames = names
+}
+
+// GetStoragepools returns IDs and names of storage pools of the VDC, they
+// must not be modified
+func (v *Vdc) GetStoragepools() ([]string, []string) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.StoragepoolIDs, v.StoragepoolNames
+}
+
+// Nodes returns nodes of the VDC ordered by IP
+func (v *Vdc) Nodes() []*Node {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	nodes := make([]*Node, 0, len(v.NodeInfo))
+	for _, n := range v.NodeInfo {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].IP < nodes[j].IP })
+	return nodes
+}
+
+// Node returns node ip of the VDC
+func (v *Vdc) Node(ip string) (*Node, bool) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	n, ok := v.NodeInfo[ip]
+	return n, ok
+}
+
+// AddNode adds node n to the VDC
+func (v *Vdc) AddNode(n *Node) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.NodeInfo[n.IP] = n
+}
+
+// RemoveNodes removes nodes whose IP is not in ips, and returns their IPs
+func (v *Vdc) RemoveNodes(ips map[string]bool) []string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	var removed []string
+	for ip := range v.NodeInfo {
+		if !ips[ip] {
+			delete(v.NodeInfo, ip)
+			removed = append(removed, ip)
+		}
+	}
+	return removed
+}
+
 func (v *Vdc) GetIpById(id string) string {
 	v.mutex.RLock()
 	defer v.mutex.RUnlock()
@@ -83,11 +154,15 @@ func (v *Vdc) GetIpById(id string) string {
 
 // Node ...
 type Node struct {
-	ID      string `json:"ecs-node-id"`
-	IP      string `json:"ecs-node-ip"`
-	Name    string `json:"ecs-node-Name"`
-	Version string `json:"ecs-version"`
-	mutex   sync.RWMutex
+	ID              string `json:"ecs-node-id"`
+	IP              string `json:"ecs-node-ip"`
+	Name            string `json:"ecs-node-Name"`
+	Version         string `json:"ecs-version"`
+	RackID          string `json:"ecs-rack-id"`
+	Status          string `json:"ecs-node-status"`
+	StoragepoolID   string `json:"ecs-storagepool-id"`
+	StoragepoolName string `json:"ecs-storagepool-name"`
+	mutex           sync.RWMutex
 }
 
 // Update ...
@@ -107,6 +182,29 @@ func (n *Node) Get() (string, string, string, string) {
 	return n.ID, n.IP, n.Name, n.Version
 }
 
+// UpdateLocation ...
+func (n *Node) UpdateLocation(rackID, status string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.RackID = rackID
+	n.Status = status
+}
+
+// UpdateStoragepool ...
+func (n *Node) UpdateStoragepool(id, name string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.StoragepoolID = id
+	n.StoragepoolName = name
+}
+
+// GetLocation returns rack ID, status, storage pool ID and storage pool name of the node
+func (n *Node) GetLocation() (string, string, string, string) {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return n.RackID, n.Status, n.StoragepoolID, n.StoragepoolName
+}
+
 func convertMapStringInterface(val interface{}) map[string]interface{} {
 	result, ok := val.(map[string]interface{})
 	if ok {
@@ -125,8 +223,42 @@ func convertMapStringInterface(val interface{}) map[string]interface{} {
 
 // Command ...
 type Command struct {
-	URI      string
-	Type     string
-	Level    string
-	Interval time.Duration
+	URI       string
+	Type      string
+	Level     string
+	Interval  time.Duration
+	Mapping   *Mapping
+	Series    *SeriesMode
+	Timestamp *TimestampSource
+	// Checkpoints and MaxLookback are used by time windowed commands only
+	Checkpoints *Checkpoints
+	MaxLookback time.Duration
+	// Dedup is set for commands publishing alerts and audit events only
+	Dedup *Dedup
+	// Units overrides built-in units of fields by name
+	Units        map[string]string
+	KeepOriginal bool
+	// Forecaster is set for storagepools command only
+	Forecaster *Forecaster
+	// Counters is set if CounterFields is not empty
+	Counters      *Counters
+	CounterFields []string
+	CounterGap    int
+	// Billing is set for nsbilling and nsbillingsample commands only
+	Billing *Billing
+	// RouteToNode is used by node level commands only
+	RouteToNode bool
+	// ClusterView and DtStates are used by dtinfo command only
+	ClusterView bool
+	DtStates    *DtStates
+	// Changes is set for disks command only, to detect changes of disk count
+	Changes *ChangeDetector
+	// Health is set for all commands if health command is enabled, to
+	// collect signals scored in health events
+	Health *Health
+	// Processors filter events of the command, nil if none is configured
+	Processors eventProcessor
+	// Pool runs queries of VDCs and nodes concurrently, they run one by one
+	// if it's nil
+	Pool *Pool
 }
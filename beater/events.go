@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"reflect"
 	"strings"
@@ -126,36 +127,141 @@ func transformEvent(event map[string]interface{}) {
 	}
 }
 
+// CommonFields are added to events of all commands by addCommonFields and buildEvent
+var CommonFields = []SchemaField{
+	{"@version", "keyword", "Version of the event format."},
+	{"type", "keyword", "Always ecsbeat."},
+	{"ecs-collected-at", "date", "Time the event was collected by ecsbeat."},
+	{"ecs-customer", "keyword", "Name of the customer in ecsbeat.yml."},
+	{"ecs-event-type", "keyword", "Type of the command generating the event."},
+	{"ecs-vdc-cfgname", "keyword", "Name of the VDC in ecsbeat.yml."},
+	{"ecs-vdc-id", "keyword", "ID of the VDC."},
+	{"ecs-vdc-name", "keyword", "Name of the VDC."},
+	{"ecs-node-ip", "keyword", "IP of the node, only if the event is on node level."},
+	{"ecs-node-name", "keyword", "Name of the node, only if the event is on node level."},
+	{"ecs-version", "keyword", "ECS version of the node, only if the event is on node level."},
+	{"ecs-rack-id", "keyword", "Rack of the node, only if the event is on node level."},
+	{"ecs-node-status", "keyword", "Status of the node, only if the event is on node level."},
+	{"ecs-storagepool-id", "keyword", "ID of the storage pool of the node, or IDs of all storage pools of the VDC if the event is on VDC level."},
+	{"ecs-storagepool-name", "keyword", "Name of the storage pool of the node, or names of all storage pools of the VDC if the event is on VDC level."},
+	{"ecs-doc-id", "keyword", "Deterministic document ID of alerts, audit events and inventory documents."},
+	{"ecs-served-by", "keyword", "Host the data is queried from, only if the event is on node level."},
+	{"ecs-index", "keyword", "Index the event is routed to, only if routing is configured."},
+	{"ecs-pipeline", "keyword", "Ingest pipeline the event is routed to, only if routing is configured."},
+}
+
+// servedBy returns the host resp is received from
+func servedBy(resp *http.Response) string {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(resp.Request.URL.Host)
+	if err != nil {
+		return resp.Request.URL.Host
+	}
+	return host
+}
+
+func addNonEmpty(event map[string]interface{}, key, value string) {
+	if value != "" {
+		event[key] = value
+	}
+}
+
 func addCommonFields(event map[string]interface{}, config *ClusterConfig, vdc, node, etype string) {
+	now := common.Time(time.Now())
 	event["@version"] = "1.0"
-	event["@timestamp"] = common.Time(time.Now())
+	event["@timestamp"] = now
+	event["ecs-collected-at"] = now
 	event["type"] = "ecsbeat"
 	event["ecs-customer"] = config.CustomerName
 	event["ecs-event-type"] = etype
 	if v, ok := config.Vdcs[vdc]; ok {
 		event["ecs-vdc-cfgname"], event["ecs-vdc-id"], event["ecs-vdc-name"] = v.Get()
-		if n, ok := v.NodeInfo[node]; ok {
+		if n, ok := v.Node(node); ok {
 			_, event["ecs-node-ip"], event["ecs-node-name"], event["ecs-version"] = n.Get()
+			rack, status, poolID, poolName := n.GetLocation()
+			addNonEmpty(event, "ecs-rack-id", rack)
+			addNonEmpty(event, "ecs-node-status", status)
+			addNonEmpty(event, "ecs-storagepool-id", poolID)
+			addNonEmpty(event, "ecs-storagepool-name", poolName)
+		} else if ids, names := v.GetStoragepools(); len(ids) > 0 {
+			event["ecs-storagepool-id"], event["ecs-storagepool-name"] = ids, names
 		}
 	}
 }
 
+// buildEvent turns a decoded ECS record into an event ready to be published,
+// followed by events derived from it
+func buildEvent(cmd *Command, config *ClusterConfig, d map[string]interface{}, vdc, node string) []common.MapStr {
+	return buildEventAt(cmd, config, d, vdc, node, time.Time{})
+}
+
+// buildEventAt is same as buildEvent, except that the event happened at t,
+// like samples of time series. If t is zero, timestamp is read from the record.
+func buildEventAt(cmd *Command, config *ClusterConfig, d map[string]interface{}, vdc, node string, t time.Time) []common.MapStr {
+	if ts, ok := cmd.Timestamp.Get(d); ok && t.IsZero() {
+		t = ts
+	}
+	at := t
+	if at.IsZero() {
+		at = time.Now()
+	}
+	var id string
+	if cmd.Dedup != nil {
+		id, _ = d["id"].(string)
+	}
+	transformEvent(d)
+	coerceEvent(cmd.Type, d)
+	normalizeUnits(cmd.Type, d, cmd.Units, cmd.KeepOriginal)
+	if cmd.Counters != nil {
+		cmd.Counters.Apply(cmd, counterKey(cmd, config, vdc, node, d), d, at)
+	}
+
+	var derived []common.MapStr
+	if used, total, ok := deriveCapacity(cmd.Type, d); ok && cmd.Forecaster != nil {
+		f := cmd.Forecaster.Update(checkpointKey(cmd, config, vdc)+"/"+fmt.Sprint(d["id"]), at, used, total)
+		f["storagepool-id"], f["storagepool-name"] = d["id"], d["name"]
+		f["forecast-source-type"] = cmd.Type
+		addCommonFields(f, config, vdc, "", "forecast")
+		derived = append(derived, f)
+	}
+	cmd.Health.ObserveEvent(cmd.Type, config.CustomerName, vdc, d, time.Now())
+
+	cmd.Mapping.Apply(d)
+	addCommonFields(d, config, vdc, node, cmd.Type)
+	if cmd.Billing != nil || isBucketType(cmd.Type) {
+		config.Namespaces.Enrich(d)
+	}
+	if id != "" {
+		d["ecs-doc-id"] = docID(config.CustomerName, cmd.Type, id)
+	}
+	// events whose timestamp is missing or fails to parse keep collection time
+	if !t.IsZero() {
+		d["@timestamp"] = common.Time(t)
+	}
+	return append([]common.MapStr{common.MapStr(d)}, derived...)
+}
+
+// buildEvents is same as buildEvent, except that every sample of time series
+// becomes its own event if series mode is enabled for cmd. published must be
+// called once the events are published.
+func buildEvents(cmd *Command, config *ClusterConfig, d map[string]interface{}, vdc, node string) ([]common.MapStr, func()) {
+	if cmd.Series != nil {
+		return cmd.Series.Expand(cmd, config, d, vdc, node)
+	}
+	return buildEvent(cmd, config, d, vdc, node), func() {}
+}
+
 func getFilledURI(cmd *Command, ip string) string {
+	if cmd.Series != nil {
+		uri := cmd.URI
+		if cmd.Type == "disks" || cmd.Type == "processes" {
+			uri = fmt.Sprintf(cmd.URI, ip)
+		}
+		return cmd.Series.URI(uri, cmd.Interval, time.Now())
+	}
 	switch cmd.Type {
-	case "nsbillingsample":
-		// -5 mins to make sure the round time won't be in the future
-		// cmd.Interval must be multiple of 5 mins
-		t := time.Now().Add(-5 * time.Minute).Round(5 * time.Minute)
-		return cmd.URI + fmt.Sprintf("&start_time=%s&end_time=%s",
-			t.Add(-cmd.Interval).Format(time.RFC3339)[:16],
-			t.Format(time.RFC3339)[:16])
-	case "alert":
-		fallthrough
-	case "auditevent":
-		t := time.Now().Add(-1 * time.Minute).Round(1 * time.Minute)
-		return cmd.URI + fmt.Sprintf("?start_time=%s&end_time=%s",
-			t.Add(-cmd.Interval).Format(time.RFC3339)[:16],
-			t.Format(time.RFC3339)[:16])
 	case "disks":
 		fallthrough
 	case "processes":
@@ -165,88 +271,89 @@ func getFilledURI(cmd *Command, ip string) string {
 	}
 }
 
-// GenerateEvents ...
-func GenerateEvents(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
+// getWindowURI fills time window w into URI of time windowed commands
+func getWindowURI(cmd *Command, w window) string {
+	sep := "?"
+	if strings.Contains(cmd.URI, "?") {
+		sep = "&"
+	}
+	return cmd.URI + fmt.Sprintf("%sstart_time=%s&end_time=%s", sep,
+		w.start.Format(time.RFC3339)[:16],
+		w.end.Format(time.RFC3339)[:16])
+}
+
+// query sends GET request to vname and publishes events in the response.
+// vdc is nil for system level commands.
+func query(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient, uri, vname string, vdc *Vdc,
 	done <-chan struct{}, out chan<- common.MapStr) (bool, error) {
 
-	switch cmd.Level {
-	case "system":
-		for vname := range config.Vdcs {
-			var resp *http.Response
-			var err error
-			if cmd.Type == "nsbilling" || cmd.Type == "nsbillingsample" {
-				ids, err := ecs.GetNamespaceIDs(client, vname)
-				if err != nil {
-					logp.Err("%s: %v", cmd.Type, err)
-					return true, err
-				}
-				nsList := struct {
-					ID []string `json:"id"`
-				}{}
-
-				for i, v := range ids {
-					nsList.ID = append(nsList.ID, v)
-					if len(nsList.ID) == 100 || i == len(ids)-1 {
-						body := new(bytes.Buffer)
-						json.NewEncoder(body).Encode(nsList)
-						headers := http.Header{}
-						headers.Set("Content-Type", "application/json")
-						resp, err = client.PostQuery(getFilledURI(cmd, ""), body, 0, headers, vname)
-						if err != nil {
-							logp.Err("%s: %v", cmd.Type, err)
-							return true, err
-						}
-						// sometimes, ECS returns nil response for nsbillingsample
-						if resp == nil {
-							logp.Err("%s: %v", cmd.Type, err)
-							return true, ErrInvalidResponseContent
-						}
-						decoded, err := DecodeResponse(resp)
-						resp.Body.Close()
-						if err != nil {
-							logp.Err("%s: %v", cmd.Type, err)
-							return true, err
-						}
-						for _, d := range decoded {
-							transformEvent(d)
-							addCommonFields(d, config, "", "", cmd.Type)
-							if !writeEvent(done, out, common.MapStr(d)) {
-								return false, nil
-							}
-						}
-						nsList.ID = []string{}
-					}
-				}
-			} else {
-				resp, err = client.GetQuery(getFilledURI(cmd, ""), vname)
-				if err != nil {
-					logp.Err("%s: %v", cmd.Type, err)
-					return true, err
-				}
-				decoded, err := DecodeResponse(resp)
-				resp.Body.Close()
-				if err != nil {
-					logp.Err("%s: %v", cmd.Type, err)
-					return true, err
-				}
-				for _, d := range decoded {
-					transformEvent(d)
-					addCommonFields(d, config, "", "", cmd.Type)
-					if !writeEvent(done, out, common.MapStr(d)) {
-						return false, nil
-					}
+	resp, err := client.GetQuery(uri, vname)
+	if err != nil {
+		logp.Err("%s: %v", cmd.Type, err)
+		return true, err
+	}
+	decoded, err := DecodeResponse(resp)
+	resp.Body.Close()
+	if err != nil {
+		logp.Err("%s: %v", cmd.Type, err)
+		return true, err
+	}
+	for _, d := range decoded {
+		var cfgname, node string
+		if vdc != nil {
+			cfgname = vdc.ConfigName
+			if cmd.Type == "nodes" {
+				if id, ok := d["id"].(string); ok {
+					node = vdc.GetIpById(id)
 				}
 			}
-			break
 		}
-	case "vdc":
-		for vname, vdc := range config.Vdcs {
-			resp, err := client.GetQuery(getFilledURI(cmd, ""), vname)
+		id, dedup := dedupID(cmd, d)
+		if dedup && cmd.Dedup.Seen(config.CustomerName, id) {
+			debugf("%s: skip %s published before", cmd.Type, id)
+			// alerts returned again are still open
+			cmd.Health.ObserveEvent(cmd.Type, config.CustomerName, cfgname, d, time.Now())
+			continue
+		}
+		events, published := buildEvents(cmd, config, d, cfgname, node)
+		if !writeEvents(done, out, events) {
+			return false, nil
+		}
+		if dedup {
+			cmd.Dedup.Add(config.CustomerName, id)
+		}
+		published()
+	}
+	return true, nil
+}
+
+// queryNsBilling posts namespace ids to vname in batches of 100 and publishes
+// billing events in the responses
+func queryNsBilling(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient, uri, vname string, ids []string,
+	done <-chan struct{}, out chan<- common.MapStr) (bool, error) {
+
+	nsList := struct {
+		ID []string `json:"id"`
+	}{}
+
+	uri = cmd.Billing.URI(uri)
+	for i, v := range ids {
+		nsList.ID = append(nsList.ID, v)
+		if len(nsList.ID) == cmd.Billing.batchSize || i == len(ids)-1 {
+			body := new(bytes.Buffer)
+			json.NewEncoder(body).Encode(nsList)
+			headers := http.Header{}
+			headers.Set("Content-Type", "application/json")
+			resp, err := client.PostQuery(uri, body, 0, headers, vname)
 			if err != nil {
 				logp.Err("%s: %v", cmd.Type, err)
 				return true, err
 			}
-
+			// sometimes, ECS returns nil response for nsbillingsample
+			if resp == nil {
+				logp.Err("%s: %v", cmd.Type, err)
+				return true, ErrInvalidResponseContent
+			}
 			decoded, err := DecodeResponse(resp)
 			resp.Body.Close()
 			if err != nil {
@@ -254,45 +361,162 @@ func GenerateEvents(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
 				return true, err
 			}
 			for _, d := range decoded {
-				transformEvent(d)
-				if cmd.Type == "nodes" {
-					if id, ok := d["id"]; ok {
-						if idstr, ok := id.(string); ok {
-							addCommonFields(d, config, vdc.ConfigName, vdc.GetIpById(idstr), cmd.Type)
-						}
-					}
-				} else {
-					addCommonFields(d, config, vdc.ConfigName, "", cmd.Type)
-				}
-				if !writeEvent(done, out, common.MapStr(d)) {
+				buckets := cmd.Billing.SplitBuckets(cmd.Type, d)
+				ts, _ := cmd.Timestamp.Get(d)
+				events, published := buildEvents(cmd, config, d, "", "")
+				if !writeEvents(done, out, events) {
 					return false, nil
 				}
+				published()
+				for _, bucket := range buckets {
+					if !writeEvents(done, out, buildEventAt(cmd.Billing.Buckets, config, bucket, "", "", ts)) {
+						return false, nil
+					}
+				}
 			}
+			nsList.ID = []string{}
 		}
-	case "node":
-		for vname, vdc := range config.Vdcs {
-			for _, node := range vdc.NodeInfo {
-				resp, err := client.GetQuery(getFilledURI(cmd, node.ID), vname)
-				if err != nil {
-					logp.Err("%s: %v", cmd.Type, err)
-					return true, err
-				}
-				decoded, err := DecodeResponse(resp)
-				resp.Body.Close()
-				if err != nil {
+	}
+	return true, nil
+}
+
+// querySystem queries system level cmd from any VDC of config
+func querySystem(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
+	done <-chan struct{}, out chan<- common.MapStr) (bool, error) {
+
+	for vname := range config.Vdcs {
+		if cmd.Type == "nsbilling" || cmd.Type == "nsbillingsample" {
+			// namespaces are listed once per refresh if they're refreshed
+			// periodically, and every poll otherwise
+			var ids []string
+			if config.CfgRefresh > 0 {
+				ids = config.Namespaces.IDs()
+			}
+			if ids == nil {
+				var err error
+				if ids, err = ecs.GetNamespaceIDs(client, vname); err != nil {
 					logp.Err("%s: %v", cmd.Type, err)
 					return true, err
 				}
-				for _, d := range decoded {
-					transformEvent(d)
-					addCommonFields(d, config, vdc.ConfigName, node.IP, cmd.Type)
-					if !writeEvent(done, out, common.MapStr(d)) {
-						return false, nil
-					}
+			}
+			ids = cmd.Billing.Namespaces(ids)
+			fn := func(uri string) (bool, error) {
+				return queryNsBilling(cmd, config, client, uri, vname, ids, done, out)
+			}
+			if isWindowed(cmd) {
+				return queryWindows(cmd, checkpointKey(cmd, config, ""), fn)
+			}
+			return fn(getFilledURI(cmd, ""))
+		}
+		return query(cmd, config, client, getFilledURI(cmd, ""), vname, nil, done, out)
+	}
+	return true, nil
+}
+
+// queryNode sends GET request of node level cmd to node of vname and
+// publishes events in the response
+func queryNode(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient, vname string, vdc *Vdc, node *Node,
+	done <-chan struct{}, out chan<- common.MapStr) (bool, error) {
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	if cmd.RouteToNode {
+		resp, err = client.GetQueryOnNode(getFilledURI(cmd, node.ID), vname, node.IP)
+	} else {
+		resp, err = client.GetQuery(getFilledURI(cmd, node.ID), vname)
+	}
+	if err != nil {
+		logp.Err("%s: %v", cmd.Type, err)
+		return true, err
+	}
+	decoded, err := DecodeResponse(resp)
+	resp.Body.Close()
+	if err != nil {
+		logp.Err("%s: %v", cmd.Type, err)
+		return true, err
+	}
+	if cmd.Type == "disks" {
+		cmd.Changes.ObserveDisks(config, vdc.ConfigName, node, len(decoded))
+	}
+	host := servedBy(resp)
+	for _, d := range decoded {
+		events, published := buildEvents(cmd, config, d, vdc.ConfigName, node.IP)
+		for _, e := range events {
+			addNonEmpty(e, "ecs-served-by", host)
+		}
+		if !writeEvents(done, out, events) {
+			return false, nil
+		}
+		published()
+	}
+	return true, nil
+}
+
+// queryWindows runs fn for every window since the last checkpoint of key,
+// checkpoint is moved forward once all events of the window are published
+func queryWindows(cmd *Command, key string, fn func(uri string) (bool, error)) (bool, error) {
+	for _, w := range getWindows(cmd, key, time.Now()) {
+		if torun, err := fn(getWindowURI(cmd, w)); !torun || err != nil {
+			return torun, err
+		}
+		cmd.Checkpoints.Set(key, w.end)
+		cmd.Dedup.Save()
+	}
+	return true, nil
+}
+
+// GenerateEvents ...
+func GenerateEvents(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
+	done <-chan struct{}, out chan<- common.MapStr) (bool, error) {
+	defer cmd.Counters.Save()
+	defer cmd.DtStates.Save()
+	defer cmd.Dedup.Save()
+
+	switch cmd.Level {
+	case "system":
+		return cmd.Pool.Run(done, config.CustomerName, []unitFunc{func() (bool, error) {
+			return querySystem(cmd, config, client, done, out)
+		}})
+	case "vdc":
+		var units []unitFunc
+		for vname, vdc := range config.Vdcs {
+			vname, vdc := vname, vdc
+			fn := func(uri string) (bool, error) {
+				return query(cmd, config, client, uri, vname, vdc, done, out)
+			}
+			units = append(units, func() (bool, error) {
+				if isWindowed(cmd) {
+					return queryWindows(cmd, checkpointKey(cmd, config, vname), fn)
 				}
+				return fn(getFilledURI(cmd, ""))
+			})
+		}
+		return cmd.Pool.Run(done, config.CustomerName, units)
+	case "node":
+		var units []unitFunc
+		for vname, vdc := range config.Vdcs {
+			for _, node := range vdc.Nodes() {
+				vname, vdc, node := vname, vdc, node
+				units = append(units, func() (bool, error) {
+					return queryNode(cmd, config, client, vname, vdc, node, done, out)
+				})
 			}
 		}
+		return cmd.Pool.Run(done, config.CustomerName, units)
+	case "inventory":
+		if !writeEvents(done, out, inventoryEvents(config)) {
+			return false, nil
+		}
+	case "health":
+		if !writeEvents(done, out, cmd.Health.Events(config, client.BlockedNodes, time.Now())) {
+			return false, nil
+		}
 	case "dtinfo":
+		if cmd.ClusterView {
+			return generateDtClusterView(cmd, config, client, done, out)
+		}
 		if cmd.Type == "dtinfo" {
 			// try each ip until we got 2 responses w/o errors
 			var fetched bool
@@ -300,7 +524,7 @@ func GenerateEvents(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
 				if fetched {
 					break
 				}
-				for _, node := range vdc.NodeInfo {
+				for _, node := range vdc.Nodes() {
 					if fetched {
 						break
 					}
@@ -315,6 +539,9 @@ func GenerateEvents(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
 						continue
 					}
 					fetched = true
+					// DTs are counted in the VDC of their owner, VDCs owning none
+					// are not observed as they may not be reported by this node
+					unready := map[string]int{vdc.ConfigName: 0}
 					for _, entry := range dtInfos.DtEntries {
 						for _, bad := range dtInits.DtEntries {
 							if bad.DtID == entry.DtID {
@@ -322,13 +549,22 @@ func GenerateEvents(cmd *Command, config *ClusterConfig, client *ecs.MgmtClient,
 								break
 							}
 						}
-						d := struct2Map(entry)
-						transformEvent(d)
-						addCommonFields(d, config, vdc.ConfigName, node.IP, cmd.Type)
-						if !writeEvent(done, out, common.MapStr(d)) {
+						owner := config.VdcOfNode(entry.DtOwnerIP)
+						if owner == "" {
+							owner = vdc.ConfigName
+						}
+						n := unready[owner]
+						if entry.DtStatus != "ready" {
+							n++
+						}
+						unready[owner] = n
+						if !writeEvents(done, out, dtEvents(cmd, config, entry, vdc.ConfigName, node.IP)) {
 							return false, nil
 						}
 					}
+					for vname, n := range unready {
+						cmd.Health.ObserveDts(config.CustomerName, vname, n)
+					}
 				}
 			}
 		}
@@ -0,0 +1,63 @@
+package beater
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestAddCommonFields ...
+func TestAddCommonFields(t *testing.T) {
+	node := &Node{ID: "n1", IP: "1.1.1.1", Name: "node1", Version: "3.0"}
+	node.UpdateLocation("red", "Good")
+	node.UpdateStoragepool("sp1", "pool1")
+	vdc := &Vdc{ConfigName: "VDC1", ID: "vdc1", Name: "vdc1", NodeInfo: map[string]*Node{node.IP: node}}
+	vdc.UpdateStoragepools([]string{"sp1", "sp2"}, []string{"pool1", "pool2"})
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{"VDC1": vdc}}
+
+	event := make(map[string]interface{})
+	addCommonFields(event, cfg, "VDC1", node.IP, "nodes")
+	ecs.AssertEqual(t, "node1", event["ecs-node-name"], "")
+	ecs.AssertEqual(t, "red", event["ecs-rack-id"], "")
+	ecs.AssertEqual(t, "Good", event["ecs-node-status"], "")
+	ecs.AssertEqual(t, "sp1", event["ecs-storagepool-id"], "")
+	ecs.AssertEqual(t, "pool1", event["ecs-storagepool-name"], "")
+
+	event = make(map[string]interface{})
+	addCommonFields(event, cfg, "VDC1", "", "localzone")
+	ecs.AssertEqual(t, []string{"sp1", "sp2"}, event["ecs-storagepool-id"], "")
+	ecs.AssertEqual(t, []string{"pool1", "pool2"}, event["ecs-storagepool-name"], "")
+	_, ok := event["ecs-rack-id"]
+	ecs.AssertEqual(t, false, ok, "")
+
+	// names of storage pools may have commas
+	vdc.UpdateStoragepools([]string{"sp1"}, []string{"pool1, hot"})
+	event = make(map[string]interface{})
+	addCommonFields(event, cfg, "VDC1", "", "localzone")
+	ecs.AssertEqual(t, []string{"pool1, hot"}, event["ecs-storagepool-name"], "")
+
+	event = make(map[string]interface{})
+	addCommonFields(event, cfg, "", "", "nsbilling")
+	_, ok = event["ecs-storagepool-id"]
+	ecs.AssertEqual(t, false, ok, "no storage pool on system level")
+}
+
+// TestServedBy ...
+func TestServedBy(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://10.1.83.51:4443/dashboard/nodes/n1/disks", nil)
+	ecs.AssertEqual(t, "10.1.83.51", servedBy(&http.Response{Request: req}), "")
+	req, _ = http.NewRequest("GET", "https://lb.example.com/dashboard/nodes/n1/disks", nil)
+	ecs.AssertEqual(t, "lb.example.com", servedBy(&http.Response{Request: req}), "")
+	ecs.AssertEqual(t, "", servedBy(&http.Response{}), "")
+}
+
+// TestVdcOfNode ...
+func TestVdcOfNode(t *testing.T) {
+	config := &ClusterConfig{Vdcs: map[string]*Vdc{
+		"VDC1": {ConfigName: "VDC1", NodeInfo: map[string]*Node{"1.1.1.1": {IP: "1.1.1.1"}}},
+		"VDC2": {ConfigName: "VDC2", NodeInfo: map[string]*Node{"2.2.2.2": {IP: "2.2.2.2"}}},
+	}}
+	ecs.AssertEqual(t, "VDC2", config.VdcOfNode("2.2.2.2"), "")
+	ecs.AssertEqual(t, "", config.VdcOfNode("3.3.3.3"), "")
+}
@@ -0,0 +1,133 @@
+package beater
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// selector is a parsed JSONPath-style field selector, one entry per path segment
+type selector []string
+
+// parseSelector parses selectors like `$.a.b`, `a.b`, `$['a.b'].c` or `$.*Current_*`
+func parseSelector(s string) (selector, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "$")
+	var (
+		result selector
+		seg    string
+	)
+	for len(s) > 0 {
+		switch {
+		case s[0] == '.':
+			s = s[1:]
+		case strings.HasPrefix(s, "['"):
+			end := strings.Index(s, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket in selector")
+			}
+			result = append(result, s[2:end])
+			s = s[end+2:]
+		default:
+			end := strings.Index(s, ".")
+			if b := strings.Index(s, "['"); b >= 0 && (end < 0 || b < end) {
+				end = b
+			}
+			if end < 0 {
+				end = len(s)
+			}
+			seg, s = s[:end], s[end:]
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", seg, err)
+			}
+			result = append(result, seg)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return result, nil
+}
+
+func parseSelectors(ss []string) ([]selector, error) {
+	result := make([]selector, 0, len(ss))
+	for _, s := range ss {
+		sel, err := parseSelector(s)
+		if err != nil {
+			return nil, fmt.Errorf("selector %q: %v", s, err)
+		}
+		result = append(result, sel)
+	}
+	return result, nil
+}
+
+// hasWildcard tells whether selector could match more than one path
+func (sel selector) hasWildcard() bool {
+	for _, seg := range sel {
+		if strings.ContainsAny(seg, "*?[") {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPaths returns the concrete paths in event matched by sel
+func selectPaths(event map[string]interface{}, sel selector) [][]string {
+	var result [][]string
+	var walk func(m map[string]interface{}, depth int, prefix []string)
+	walk = func(m map[string]interface{}, depth int, prefix []string) {
+		for k, v := range m {
+			if ok, _ := path.Match(sel[depth], k); !ok {
+				continue
+			}
+			p := append(append([]string{}, prefix...), k)
+			if depth == len(sel)-1 {
+				result = append(result, p)
+			} else if sub, ok := v.(map[string]interface{}); ok {
+				walk(sub, depth+1, p)
+			}
+		}
+	}
+	walk(event, 0, nil)
+	return result
+}
+
+func getPath(event map[string]interface{}, p []string) (interface{}, bool) {
+	var cur interface{} = event
+	for _, k := range p {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// putPath sets value at p, creating intermediate objects as needed
+func putPath(event map[string]interface{}, p []string, value interface{}) {
+	m := event
+	for _, k := range p[:len(p)-1] {
+		sub, ok := m[k].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[k] = sub
+		}
+		m = sub
+	}
+	m[p[len(p)-1]] = value
+}
+
+func deletePath(event map[string]interface{}, p []string) {
+	m := event
+	for _, k := range p[:len(p)-1] {
+		sub, ok := m[k].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = sub
+	}
+	delete(m, p[len(p)-1])
+}
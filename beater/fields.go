@@ -0,0 +1,210 @@
+package beater
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// TemplateVersion is the libbeat version ecsbeat is built with
+const TemplateVersion = "5.2.2"
+
+// libbeatFields are added to every event by libbeat
+var libbeatFields = []SchemaField{
+	{"@timestamp", "date", "Time the event happened."},
+	{"beat.hostname", "keyword", "Hostname of the server running ecsbeat."},
+	{"beat.name", "keyword", "Name of the beat."},
+	{"beat.version", "keyword", "Version of the beat."},
+	{"meta.cloud.availability_zone", "keyword", "Availability zone of the cloud instance."},
+	{"meta.cloud.instance_id", "keyword", "ID of the cloud instance."},
+	{"meta.cloud.machine_type", "keyword", "Machine type of the cloud instance."},
+	{"meta.cloud.project_id", "keyword", "Project ID of the cloud instance."},
+	{"meta.cloud.provider", "keyword", "Name of the cloud provider."},
+	{"meta.cloud.region", "keyword", "Region of the cloud instance."},
+	{"tags", "keyword", "Tags of the shipper."},
+}
+
+// schemaTypeNames returns command types in Schemas in sorted order
+func schemaTypeNames() []string {
+	var result []string
+	for etype := range Schemas {
+		result = append(result, etype)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Generate writes generated fields.yml, index template, or index template of
+// the ecs layout to w
+func Generate(what string, w io.Writer) error {
+	switch what {
+	case "fields":
+		return WriteFieldsYML(w)
+	case "template":
+		return WriteTemplate(w)
+	case "template-ecs":
+		return WriteECSTemplate(w)
+	}
+	return fmt.Errorf("unknown generator %q, must be fields, template or template-ecs", what)
+}
+
+func writeFieldsSection(w io.Writer, key, description string, fields []SchemaField) error {
+	fmt.Fprintf(w, "- key: %s\n", key)
+	fmt.Fprintf(w, "  title: %s\n", key)
+	fmt.Fprintf(w, "  description: >\n    %s\n", description)
+	fmt.Fprintf(w, "  fields:\n")
+	for _, f := range fields {
+		fmt.Fprintf(w, "    - name: %s\n", f.Name)
+		fmt.Fprintf(w, "      type: %s\n", f.Type)
+		fmt.Fprintf(w, "      description: >\n        %s\n", f.Description)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// WriteFieldsYML renders CommonFields and Schemas in fields.yml format of libbeat
+func WriteFieldsYML(w io.Writer) error {
+	if err := writeFieldsSection(w, "common", "Fields added to events of all commands.", CommonFields); err != nil {
+		return err
+	}
+	for _, etype := range schemaTypeNames() {
+		if err := writeFieldsSection(w, etype, fmt.Sprintf("Fields of %s events.", etype), schemaFields(etype)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateProperties merges fields into Elasticsearch mapping properties,
+// dots in field names become object properties
+func templateProperties(properties map[string]interface{}, fields []SchemaField, declared map[string]string) error {
+	for _, f := range fields {
+		if typ, ok := declared[f.Name]; ok {
+			if typ != f.Type {
+				return fmt.Errorf("field %s is declared as both %s and %s", f.Name, typ, f.Type)
+			}
+			continue
+		}
+		declared[f.Name] = f.Type
+
+		parts := strings.Split(f.Name, ".")
+		props := properties
+		for _, p := range parts[:len(parts)-1] {
+			obj, ok := props[p].(map[string]interface{})
+			if !ok {
+				obj = map[string]interface{}{"properties": map[string]interface{}{}}
+				props[p] = obj
+			}
+			props = obj["properties"].(map[string]interface{})
+		}
+		props[parts[len(parts)-1]] = fieldMapping(f.Type)
+	}
+	return nil
+}
+
+func fieldMapping(typ string) map[string]interface{} {
+	if typ == "keyword" {
+		return map[string]interface{}{"type": typ, "ignore_above": 1024}
+	}
+	return map[string]interface{}{"type": typ}
+}
+
+// templateFields returns mapping properties of all declared fields, and
+// declared type of every field by full name
+func templateFields() (map[string]interface{}, map[string]string, error) {
+	properties := make(map[string]interface{})
+	declared := make(map[string]string)
+	if err := templateProperties(properties, libbeatFields, declared); err != nil {
+		return nil, nil, err
+	}
+	if err := templateProperties(properties, CommonFields, declared); err != nil {
+		return nil, nil, err
+	}
+	for _, etype := range schemaTypeNames() {
+		if err := templateProperties(properties, schemaFields(etype), declared); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", etype, err)
+		}
+	}
+	return properties, declared, nil
+}
+
+// ecsTemplateFields is same as templateFields, except that fields are those
+// of the ecs layout
+func ecsTemplateFields() (map[string]interface{}, map[string]string, error) {
+	properties := make(map[string]interface{})
+	declared := make(map[string]string)
+	if err := templateProperties(properties, libbeatFields, declared); err != nil {
+		return nil, nil, err
+	}
+	if err := templateProperties(properties, ECSLayoutFields, declared); err != nil {
+		return nil, nil, err
+	}
+	for _, etype := range schemaTypeNames() {
+		var fields []SchemaField
+		for _, f := range schemaFields(etype) {
+			f.Name = ecsNamespace + "." + ecsStorageName(f.Name)
+			fields = append(fields, f)
+		}
+		if err := templateProperties(properties, fields, declared); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", etype, err)
+		}
+	}
+	return properties, declared, nil
+}
+
+// WriteTemplate renders the Elasticsearch index template of ecsbeat-* from
+// CommonFields, Schemas and SchemaPatterns
+func WriteTemplate(w io.Writer) error {
+	properties, _, err := templateFields()
+	if err != nil {
+		return err
+	}
+	return writeTemplate(w, properties)
+}
+
+// WriteECSTemplate renders the index template of ecsbeat-* for events of the
+// ecs layout
+func WriteECSTemplate(w io.Writer) error {
+	properties, _, err := ecsTemplateFields()
+	if err != nil {
+		return err
+	}
+	return writeTemplate(w, properties)
+}
+
+func writeTemplate(w io.Writer, properties map[string]interface{}) error {
+	var dynamic []interface{}
+	for _, p := range SchemaPatterns {
+		dynamic = append(dynamic, map[string]interface{}{
+			p.Name: map[string]interface{}{
+				"match":   p.Match,
+				"mapping": fieldMapping(p.Type),
+			},
+		})
+	}
+
+	template := map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"_default_": map[string]interface{}{
+				"_all":              map[string]interface{}{"norms": false},
+				"_meta":             map[string]interface{}{"version": TemplateVersion},
+				"properties":        properties,
+				"dynamic_templates": dynamic,
+			},
+		},
+		"order": 10,
+		"settings": map[string]interface{}{
+			"index.mapping.total_fields.limit": 10000,
+			"index.refresh_interval":           "5s",
+		},
+		"template": "ecsbeat-*",
+	}
+	b, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
@@ -0,0 +1,116 @@
+package beater
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// responses are trimmed ECS responses of built-in commands
+var responses = map[string]string{
+	"localzone": `{"id": "vdc1", "name": "vdc1", "numNodes": "4", "numBadDisks": "0",
+		"diskSpaceTotalCurrent": [{"Space": "1024", "t": "1490000000"}],
+		"nodeCpuUtilizationAvgCurrent": [{"Percent": "2.5", "t": "1490000000"}],
+		"transactionErrorsCurrent": {"all": [{"Rate": "0", "t": "1490000000"}]},
+		"_links": {"self": {"href": "/dashboard/zones/localzone"}}}`,
+	"storagepools": `{"_embedded": {"_instances": [{"id": "sp1", "name": "pool1", "numNodes": 4,
+		"diskSpaceTotalCurrent": [{"Space": 1024, "t": "1490000000"}],
+		"diskSpaceFreeCurrent": [{"Space": 512, "t": "1490000000"}]}]}}`,
+	"alert": `{"alert": [{"id": "a1", "severity": "CRITICAL", "symptomCode": "1", "description": "disk failure",
+		"namespace": "ns1", "timestamp": "2017-03-01T10:00:00.000", "acknowledged": false}]}`,
+	"nsbillingsample": `{"namespace_billing_sample_infos": [{"namespace": "ns1", "total_size": "1.5",
+		"total_size_unit": "GB", "total_objects": "10", "objects_created": "1", "objects_deleted": "0",
+		"sample_time_range": {"start_time": "2017-03-01T10:00", "end_time": "2017-03-01T10:05"}}]}`,
+}
+
+// flattenKeys returns full names of all leaf fields of event
+func flattenKeys(prefix string, event map[string]interface{}) []string {
+	var result []string
+	for k, v := range event {
+		if sub, ok := v.(map[string]interface{}); ok {
+			result = append(result, flattenKeys(prefix+k+".", sub)...)
+		} else {
+			result = append(result, prefix+k)
+		}
+	}
+	return result
+}
+
+func inTemplate(declared map[string]string, name string) bool {
+	if _, ok := declared[name]; ok {
+		return true
+	}
+	for _, p := range SchemaPatterns {
+		if ok, _ := path.Match(p.Match, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TestEmittedFieldsInTemplate fails if an emitted field is missing from the template
+func TestEmittedFieldsInTemplate(t *testing.T) {
+	_, declared, err := templateFields()
+	ecs.AssertEqualFatal(t, nil, err, "")
+
+	node := &Node{ID: "n1", IP: "1.1.1.1", Name: "node1", Version: "3.0"}
+	cfg := &ClusterConfig{
+		CustomerName: "c1",
+		Vdcs: map[string]*Vdc{
+			"VDC1": {ConfigName: "VDC1", ID: "vdc1", Name: "vdc1", NodeInfo: map[string]*Node{node.IP: node}},
+		},
+	}
+	registry, _ := NewRegistry("")
+	var events []common.MapStr
+	for etype, resp := range responses {
+		decoded, err := DecodeResponse(&http.Response{Body: ioutil.NopCloser(strings.NewReader(resp))})
+		ecs.AssertEqualFatal(t, nil, err, etype)
+		cmd := &Command{Type: etype}
+		if isDeduplicated(cmd) {
+			cmd.Dedup = NewDedup(registry, 10)
+		}
+		if etype == "storagepools" {
+			cmd.Forecaster = NewForecaster(registry, config.Forecast{History: time.Hour, Thresholds: []float64{0.9}})
+		}
+		for _, d := range decoded {
+			events = append(events, buildEvent(cmd, cfg, d, "VDC1", node.IP)...)
+		}
+	}
+	events = append(events, buildEvent(&Command{Type: "dtinfo"}, cfg, struct2Map(ecs.DtEntry{}), "VDC1", node.IP)...)
+
+	var missing []string
+	for _, event := range events {
+		for _, name := range flattenKeys("", event) {
+			if !inTemplate(declared, name) {
+				missing = append(missing, event["ecs-event-type"].(string)+": "+name)
+			}
+		}
+	}
+	sort.Strings(missing)
+	ecs.AssertEqual(t, "", strings.Join(missing, ", "), "fields missing from template")
+}
+
+// TestGeneratedFiles fails if _meta/fields.yml or ecsbeat.template.json is
+// not up to date, run `ecsbeat generate fields|template` to regenerate them
+func TestGeneratedFiles(t *testing.T) {
+	for what, file := range map[string]string{
+		"fields":       "../_meta/fields.yml",
+		"template":     "../ecsbeat.template.json",
+		"template-ecs": "../ecsbeat.ecs.template.json",
+	} {
+		var b bytes.Buffer
+		ecs.AssertEqualFatal(t, nil, Generate(what, &b), what)
+		content, err := ioutil.ReadFile(file)
+		ecs.AssertEqualFatal(t, nil, err, file)
+		ecs.AssertEqual(t, b.String(), string(content), file+" is out of date")
+	}
+}
@@ -0,0 +1,304 @@
+package beater
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+// health factors in the order they are reported
+var healthFactors = []string{"critical_alerts", "unready_dts", "blocked_nodes", "capacity_utilization", "failed_fetches"}
+
+// HealthFields are fields of health events besides common fields
+var HealthFields = healthFields()
+
+func healthFields() []SchemaField {
+	fields := []SchemaField{
+		{"health-scope", "keyword", "Whether the score is of a vdc or a customer."},
+		{"health-score", "double", "Health score from 0 to 100, 100 being healthy."},
+		{"health-worst-factor", "keyword", "Factor with the highest weighted penalty, missing if none is penalised."},
+	}
+	for _, f := range healthFactors {
+		fields = append(fields,
+			SchemaField{"health-factors." + f + ".value", "double", "Observed " + strings.Replace(f, "_", " ", -1) + "."},
+			SchemaField{"health-factors." + f + ".penalty", "double", "Penalty of " + strings.Replace(f, "_", " ", -1) + " from 0 to 1."},
+			SchemaField{"health-factors." + f + ".weight", "double", "Weight of " + strings.Replace(f, "_", " ", -1) + "."},
+		)
+	}
+	return fields
+}
+
+type healthVdc struct {
+	// alerts are open critical alerts by ID, with when they were last seen
+	alerts map[string]time.Time
+	// capacity is utilisation of the VDC and each of its storage pools
+	capacity   map[string]float64
+	unreadyDts int
+	dtsSeen    bool
+}
+
+type fetchResult struct {
+	T  time.Time
+	OK bool
+}
+
+type healthCustomer struct {
+	vdcs    map[string]*healthVdc
+	fetches []fetchResult
+}
+
+// Health collects signals of every customer and VDC from other commands, and
+// scores them in health events. Signals are kept in memory only.
+type Health struct {
+	mutex     sync.Mutex
+	config    config.Health
+	customers map[string]*healthCustomer
+}
+
+// NewHealth ...
+func NewHealth(c config.Health) *Health {
+	return &Health{config: c, customers: make(map[string]*healthCustomer)}
+}
+
+func (h *Health) customer(name string) *healthCustomer {
+	c, ok := h.customers[name]
+	if !ok {
+		c = &healthCustomer{vdcs: make(map[string]*healthVdc)}
+		h.customers[name] = c
+	}
+	return c
+}
+
+func (h *Health) vdc(customer, vdc string) *healthVdc {
+	c := h.customer(customer)
+	v, ok := c.vdcs[vdc]
+	if !ok {
+		v = &healthVdc{alerts: make(map[string]time.Time), capacity: make(map[string]float64)}
+		c.vdcs[vdc] = v
+	}
+	return v
+}
+
+// ObserveEvent picks open critical alerts and capacity utilisation out of
+// event of type etype, after it's coerced and normalised
+func (h *Health) ObserveEvent(etype, customer, vdc string, event map[string]interface{}, now time.Time) {
+	if h == nil || vdc == "" {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if etype == "alert" || etype == "latestalert" {
+		id, _ := event["id"].(string)
+		if id == "" {
+			return
+		}
+		v := h.vdc(customer, vdc)
+		severity, _ := event["severity"].(string)
+		if acked, _ := event["acknowledged"].(bool); acked || !strings.EqualFold(severity, "CRITICAL") {
+			delete(v.alerts, id)
+		} else {
+			v.alerts[id] = now
+		}
+		return
+	}
+	if u, ok := event["capacityUtilization_ratio"].(float64); ok {
+		h.vdc(customer, vdc).capacity[etype+"/"+fmt.Sprint(event["id"])] = u
+	}
+}
+
+// ObserveDts records how many DTs are not ready, as of the latest dtinfo poll
+func (h *Health) ObserveDts(customer, vdc string, unready int) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	v := h.vdc(customer, vdc)
+	v.unreadyDts, v.dtsSeen = unready, true
+}
+
+// ObserveFetch records whether a command fetched from customer successfully
+func (h *Health) ObserveFetch(customer string, ok bool, now time.Time) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	c := h.customer(customer)
+	c.fetches = append(c.fetches, fetchResult{now, ok})
+}
+
+// healthScore accumulates weighted penalties of factors
+type healthScore struct {
+	factors  map[string]interface{}
+	penalty  float64
+	weights  float64
+	worst    string
+	worstPen float64
+}
+
+func (s *healthScore) add(name string, value, penalty, weight float64) {
+	if penalty < 0 {
+		penalty = 0
+	} else if penalty > 1 {
+		penalty = 1
+	}
+	s.factors[name] = map[string]interface{}{"value": value, "penalty": penalty, "weight": weight}
+	s.penalty += penalty * weight
+	s.weights += weight
+	if penalty*weight > s.worstPen {
+		s.worst, s.worstPen = name, penalty*weight
+	}
+}
+
+func (s *healthScore) event(scope string) common.MapStr {
+	score := 100.0
+	if s.weights > 0 {
+		score = 100 * (1 - s.penalty/s.weights)
+	}
+	e := common.MapStr{"health-scope": scope, "health-score": score, "health-factors": s.factors}
+	addNonEmpty(e, "health-worst-factor", s.worst)
+	return e
+}
+
+// ratio is penalty of value, from 0 at start to 1 at full
+func ratio(value, start, full float64) float64 {
+	if full <= start {
+		if value > start {
+			return 1
+		}
+		return 0
+	}
+	return (value - start) / (full - start)
+}
+
+// unavailableNodes returns how many nodes of vdc are down or blocked, out of
+// blocked and total nodes of the client. A blocked node may be down as well,
+// so the larger count is taken rather than the sum.
+func unavailableNodes(vdc *Vdc, blocked, total int) (int, int) {
+	if vdc == nil {
+		return blocked, total
+	}
+	nodes := vdc.Nodes()
+	var down int
+	for _, n := range nodes {
+		if _, status, _, _ := n.GetLocation(); status != "" && status != "Good" {
+			down++
+		}
+	}
+	if len(nodes) > total {
+		total = len(nodes)
+	}
+	if down > blocked {
+		blocked = down
+	}
+	return blocked, total
+}
+
+// Events scores each VDC of config and the customer as a whole at now.
+// blocked returns how many nodes of a VDC are blocked and how many it has.
+// Nodes whose status of the latest config refresh is not Good are down.
+// DTs, nodes and capacity not observed yet are left out of the score.
+func (h *Health) Events(config *ClusterConfig, blocked func(vdc string) (int, int), now time.Time) []common.MapStr {
+	if h == nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	w, th := h.config.Weights, h.config.Thresholds
+	c := h.customer(config.CustomerName)
+
+	// failed fetches are known per customer only, and apply to its VDCs too
+	var failed, fetches int
+	kept := c.fetches[:0]
+	for _, f := range c.fetches {
+		if now.Sub(f.T) > h.config.Window {
+			continue
+		}
+		kept = append(kept, f)
+		fetches++
+		if !f.OK {
+			failed++
+		}
+	}
+	c.fetches = kept
+	addFetches := func(s *healthScore) {
+		if fetches > 0 {
+			r := float64(failed) / float64(fetches)
+			s.add("failed_fetches", r, ratio(r, 0, th.FailedFetches), w.FailedFetches)
+		}
+	}
+
+	var vnames []string
+	for vname := range config.Vdcs {
+		vnames = append(vnames, vname)
+	}
+	sort.Strings(vnames)
+	var (
+		events                        []common.MapStr
+		alerts, dts, nblocked, nnodes int
+		dtsSeen, capacitySeen         bool
+		utilization                   float64
+	)
+	for _, vname := range vnames {
+		v := h.vdc(config.CustomerName, vname)
+		s := &healthScore{factors: make(map[string]interface{})}
+		for id, seen := range v.alerts {
+			if now.Sub(seen) > h.config.Window {
+				delete(v.alerts, id)
+			}
+		}
+		alerts += len(v.alerts)
+		s.add("critical_alerts", float64(len(v.alerts)), ratio(float64(len(v.alerts)), 0, float64(th.CriticalAlerts)), w.CriticalAlerts)
+		if v.dtsSeen {
+			dts, dtsSeen = dts+v.unreadyDts, true
+			s.add("unready_dts", float64(v.unreadyDts), ratio(float64(v.unreadyDts), 0, float64(th.UnreadyDts)), w.UnreadyDts)
+		}
+		b, n := blocked(vname)
+		if b, n = unavailableNodes(config.Vdcs[vname], b, n); n > 0 {
+			nblocked, nnodes = nblocked+b, nnodes+n
+			r := float64(b) / float64(n)
+			s.add("blocked_nodes", r, ratio(r, 0, th.BlockedNodes), w.BlockedNodes)
+		}
+		if len(v.capacity) > 0 {
+			// a VDC is as full as its fullest storage pool
+			var u float64
+			for _, x := range v.capacity {
+				if x > u {
+					u = x
+				}
+			}
+			if u > utilization {
+				utilization = u
+			}
+			capacitySeen = true
+			s.add("capacity_utilization", u, ratio(u, th.CapacityWarning, th.Capacity), w.Capacity)
+		}
+		addFetches(s)
+		e := s.event("vdc")
+		addCommonFields(e, config, vname, "", "health")
+		events = append(events, e)
+	}
+
+	s := &healthScore{factors: make(map[string]interface{})}
+	s.add("critical_alerts", float64(alerts), ratio(float64(alerts), 0, float64(th.CriticalAlerts)), w.CriticalAlerts)
+	if dtsSeen {
+		s.add("unready_dts", float64(dts), ratio(float64(dts), 0, float64(th.UnreadyDts)), w.UnreadyDts)
+	}
+	if nnodes > 0 {
+		r := float64(nblocked) / float64(nnodes)
+		s.add("blocked_nodes", r, ratio(r, 0, th.BlockedNodes), w.BlockedNodes)
+	}
+	if capacitySeen {
+		s.add("capacity_utilization", utilization, ratio(utilization, th.CapacityWarning, th.Capacity), w.Capacity)
+	}
+	addFetches(s)
+	e := s.event("customer")
+	addCommonFields(e, config, "", "", "health")
+	return append(events, e)
+}
@@ -0,0 +1,93 @@
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestHealthEvents ...
+func TestHealthEvents(t *testing.T) {
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{
+		"VDC1": {ConfigName: "VDC1", ID: "vdc1", Name: "vdc1"},
+		"VDC2": {ConfigName: "VDC2", ID: "vdc2", Name: "vdc2"},
+	}}
+	blocked := func(vdc string) (int, int) {
+		if vdc == "VDC2" {
+			return 1, 4
+		}
+		return 0, 4
+	}
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := NewHealth(config.DefaultConfig.Health)
+
+	// nothing observed but nodes, all is well
+	events := h.Events(cfg, blocked, now)
+	ecs.AssertEqualFatal(t, 3, len(events), "")
+	ecs.AssertEqual(t, 100.0, events[0]["health-score"], "")
+	_, ok := events[0]["health-worst-factor"]
+	ecs.AssertEqual(t, false, ok, "")
+
+	h.ObserveEvent("alert", "c1", "VDC1", map[string]interface{}{"id": "a1", "severity": "CRITICAL", "acknowledged": false}, now)
+	h.ObserveEvent("alert", "c1", "VDC1", map[string]interface{}{"id": "a2", "severity": "CRITICAL", "acknowledged": false}, now)
+	h.ObserveEvent("alert", "c1", "VDC1", map[string]interface{}{"id": "a3", "severity": "WARNING", "acknowledged": false}, now)
+	// acknowledged alerts are no longer open
+	h.ObserveEvent("latestalert", "c1", "VDC1", map[string]interface{}{"id": "a2", "severity": "CRITICAL", "acknowledged": true}, now)
+	h.ObserveEvent("storagepools", "c1", "VDC1", map[string]interface{}{"id": "sp1", "capacityUtilization_ratio": 0.5}, now)
+	h.ObserveEvent("storagepools", "c1", "VDC1", map[string]interface{}{"id": "sp2", "capacityUtilization_ratio": 0.9}, now)
+	h.ObserveDts("c1", "VDC2", 5)
+	h.ObserveFetch("c1", true, now.Add(-2*time.Hour))
+	h.ObserveFetch("c1", false, now)
+	h.ObserveFetch("c1", true, now)
+	h.ObserveFetch("c1", true, now)
+	h.ObserveFetch("c1", true, now)
+
+	events = h.Events(cfg, blocked, now)
+	ecs.AssertEqualFatal(t, 3, len(events), "")
+	factors := func(i int, name string) map[string]interface{} {
+		f, _ := events[i]["health-factors"].(map[string]interface{})[name].(map[string]interface{})
+		return f
+	}
+	// VDC1: 1 of 5 critical alerts, fullest pool 2/3 of the way from 0.8 to 0.95
+	ecs.AssertEqual(t, "VDC1", events[0]["ecs-vdc-cfgname"], "")
+	ecs.AssertEqual(t, "vdc", events[0]["health-scope"], "")
+	ecs.AssertEqual(t, 1.0, factors(0, "critical_alerts")["value"], "")
+	ecs.AssertEqual(t, 0.9, factors(0, "capacity_utilization")["value"], "")
+	ecs.AssertEqual(t, 0.0, factors(0, "blocked_nodes")["penalty"], "")
+	ecs.AssertEqual(t, 0.25, factors(0, "failed_fetches")["value"], "")
+	ecs.AssertEqual(t, true, factors(0, "unready_dts") == nil, "")
+	ecs.AssertEqual(t, "capacity_utilization", events[0]["health-worst-factor"], "")
+	score := events[0]["health-score"].(float64)
+	// 6 + 0 + 10 + 7.5 points of 80 weights observed
+	ecs.AssertEqual(t, true, score > 70.6 && score < 70.7, "")
+	// VDC2: 5 of 10 unready DTs, 1 of 4 nodes blocked
+	ecs.AssertEqual(t, 0.5, factors(1, "unready_dts")["penalty"], "")
+	ecs.AssertEqual(t, 0.5, factors(1, "blocked_nodes")["penalty"], "")
+	// customer adds up alerts, DTs and nodes, and takes the fullest VDC
+	ecs.AssertEqual(t, "customer", events[2]["health-scope"], "")
+	ecs.AssertEqual(t, 5.0, factors(2, "unready_dts")["value"], "")
+	ecs.AssertEqual(t, 0.125, factors(2, "blocked_nodes")["value"], "")
+	ecs.AssertEqual(t, 0.9, factors(2, "capacity_utilization")["value"], "")
+
+	// alerts not seen within window are considered closed
+	events = h.Events(cfg, blocked, now.Add(2*time.Hour))
+	ecs.AssertEqual(t, 0.0, factors(0, "critical_alerts")["value"], "")
+
+	// nodes down are scored with blocking disabled
+	cfg.Vdcs["VDC1"].NodeInfo = map[string]*Node{"1.1.1.1": {IP: "1.1.1.1"}, "2.2.2.2": {IP: "2.2.2.2"}}
+	cfg.Vdcs["VDC1"].NodeInfo["1.1.1.1"].UpdateLocation("rack1", "Bad")
+	cfg.Vdcs["VDC1"].NodeInfo["2.2.2.2"].UpdateLocation("rack1", "Good")
+	events = h.Events(cfg, func(string) (int, int) { return 0, 0 }, now)
+	ecs.AssertEqual(t, 0.5, factors(0, "blocked_nodes")["value"], "")
+	ecs.AssertEqual(t, true, factors(1, "blocked_nodes") == nil, "no nodes known")
+
+	_, declared, err := templateFields()
+	ecs.AssertEqualFatal(t, nil, err, "")
+	for _, event := range events {
+		for _, name := range flattenKeys("", event) {
+			ecs.AssertEqual(t, true, inTemplate(declared, name), name)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package beater
+
+import (
+	"sort"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// InventoryFields are fields of inventory documents besides common fields
+var InventoryFields = []SchemaField{
+	{"inventory-kind", "keyword", "Entity the document describes: customer, vdc or node."},
+	{"inventory-vdcs", "keyword", "Config names of VDCs of the customer."},
+	{"inventory-vdc-count", "integer", "Number of VDCs of the customer."},
+	{"inventory-nodes", "keyword", "Names of nodes of the customer or VDC."},
+	{"inventory-node-count", "integer", "Number of nodes of the customer or VDC."},
+	{"ecs-node-id", "keyword", "ID of the node."},
+}
+
+// inventoryEvents builds one document per customer, VDC and node of config,
+// with ecs-doc-id stable across runs, so that documents of an index are
+// overwritten rather than duplicated
+func inventoryEvents(config *ClusterConfig) []common.MapStr {
+	var (
+		events         []common.MapStr
+		vnames, nnames []string
+	)
+	for vname := range config.Vdcs {
+		vnames = append(vnames, vname)
+	}
+	sort.Strings(vnames)
+	for _, vname := range vnames {
+		vdc := config.Vdcs[vname]
+		var names []string
+		for _, node := range vdc.Nodes() {
+			id, ip, name, _ := node.Get()
+			names = append(names, name)
+			event := common.MapStr{"inventory-kind": "node", "ecs-node-id": id}
+			addCommonFields(event, config, vname, ip, "inventory")
+			event["ecs-doc-id"] = docID(config.CustomerName, "inventory", "node", vname, ip)
+			events = append(events, event)
+		}
+		nnames = append(nnames, names...)
+
+		event := common.MapStr{
+			"inventory-kind":       "vdc",
+			"inventory-nodes":      names,
+			"inventory-node-count": len(names),
+		}
+		addCommonFields(event, config, vname, "", "inventory")
+		event["ecs-doc-id"] = docID(config.CustomerName, "inventory", "vdc", vname)
+		events = append(events, event)
+	}
+
+	event := common.MapStr{
+		"inventory-kind":       "customer",
+		"inventory-vdcs":       vnames,
+		"inventory-vdc-count":  len(vnames),
+		"inventory-nodes":      nnames,
+		"inventory-node-count": len(nnames),
+	}
+	addCommonFields(event, config, "", "", "inventory")
+	event["ecs-doc-id"] = docID(config.CustomerName, "inventory", "customer")
+	return append(events, event)
+}
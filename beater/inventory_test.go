@@ -0,0 +1,45 @@
+package beater
+
+import (
+	"testing"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestInventoryEvents ...
+func TestInventoryEvents(t *testing.T) {
+	node1 := &Node{ID: "n1", IP: "1.1.1.1", Name: "node1", Version: "3.0"}
+	node2 := &Node{ID: "n2", IP: "2.2.2.2", Name: "node2", Version: "3.0"}
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{
+		"VDC2": {ConfigName: "VDC2", ID: "vdc2", Name: "vdc2", NodeInfo: map[string]*Node{node2.IP: node2}},
+		"VDC1": {ConfigName: "VDC1", ID: "vdc1", Name: "vdc1", NodeInfo: map[string]*Node{node1.IP: node1}},
+	}}
+	events := inventoryEvents(cfg)
+	ecs.AssertEqualFatal(t, 5, len(events), "")
+	ecs.AssertEqual(t, "node", events[0]["inventory-kind"], "")
+	ecs.AssertEqual(t, "n1", events[0]["ecs-node-id"], "")
+	ecs.AssertEqual(t, "node1", events[0]["ecs-node-name"], "")
+	ecs.AssertEqual(t, "vdc", events[1]["inventory-kind"], "")
+	ecs.AssertEqual(t, "vdc1", events[1]["ecs-vdc-name"], "")
+	ecs.AssertEqual(t, []string{"node1"}, events[1]["inventory-nodes"], "")
+	ecs.AssertEqual(t, "customer", events[4]["inventory-kind"], "")
+	ecs.AssertEqual(t, []string{"VDC1", "VDC2"}, events[4]["inventory-vdcs"], "")
+	ecs.AssertEqual(t, 2, events[4]["inventory-node-count"], "")
+
+	// document IDs are stable and unique per entity
+	again := inventoryEvents(cfg)
+	ids := make(map[interface{}]bool)
+	for i, event := range events {
+		ecs.AssertEqual(t, event["ecs-doc-id"], again[i]["ecs-doc-id"], "")
+		ids[event["ecs-doc-id"]] = true
+	}
+	ecs.AssertEqual(t, len(events), len(ids), "")
+
+	_, declared, err := templateFields()
+	ecs.AssertEqualFatal(t, nil, err, "")
+	for _, event := range events {
+		for _, name := range flattenKeys("", event) {
+			ecs.AssertEqual(t, true, inTemplate(declared, name), name)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package beater
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// ECSVersion is the version of Elastic Common Schema events of the ecs
+// layout follow
+const ECSVersion = "1.12.0"
+
+// ecsNamespace is the object ECS storage specific fields are nested under
+// in the ecs layout
+const ecsNamespace = "ecs_storage"
+
+// ecsLayoutFields are where common fields go in the ecs layout
+var ecsLayoutFields = map[string]string{
+	"ecs-customer":         "organization.name",
+	"ecs-node-ip":          "host.ip",
+	"ecs-node-name":        "host.name",
+	"ecs-collected-at":     "event.created",
+	"ecs-doc-id":           "event.id",
+	"ecs-version":          ecsNamespace + ".version",
+	"ecs-vdc-cfgname":      ecsNamespace + ".vdc.cfgname",
+	"ecs-vdc-id":           ecsNamespace + ".vdc.id",
+	"ecs-vdc-name":         ecsNamespace + ".vdc.name",
+	"ecs-rack-id":          ecsNamespace + ".rack.id",
+	"ecs-node-status":      ecsNamespace + ".node.status",
+	"ecs-storagepool-id":   ecsNamespace + ".storagepool.id",
+	"ecs-storagepool-name": ecsNamespace + ".storagepool.name",
+	"ecs-served-by":        ecsNamespace + ".served_by",
+}
+
+// ECSLayoutFields are Elastic Common Schema fields of the ecs layout, other
+// fields are nested under ecs_storage
+var ECSLayoutFields = []SchemaField{
+	{"ecs.version", "keyword", "Version of Elastic Common Schema the event follows."},
+	{"organization.name", "keyword", "Name of the customer in ecsbeat.yml."},
+	{"host.ip", "ip", "IP of the node, only if the event is on node level."},
+	{"host.name", "keyword", "Name of the node, only if the event is on node level."},
+	{"event.kind", "keyword", "alert, event, metric or state."},
+	{"event.category", "keyword", "Categories of alerts and audit events."},
+	{"event.type", "keyword", "Types of alerts and audit events."},
+	{"event.severity", "long", "Severity of alerts, 2 for critical down to 6 for info."},
+	{"event.action", "keyword", "Type of audit events, or status of ecsbeat-alert events."},
+	{"event.module", "keyword", "Always ecsbeat."},
+	{"event.dataset", "keyword", "ecsbeat followed by type of the command generating the event."},
+	{"event.created", "date", "Time the event was collected by ecsbeat."},
+	{"event.id", "keyword", "Deterministic document ID of alerts, audit events and inventory documents."},
+	{"log.level", "keyword", "Severity of alerts in lower case."},
+	{"message", "text", "Description of alerts and audit events."},
+	{"user.id", "keyword", "User triggering audit events."},
+	{ecsNamespace + ".version", "keyword", "ECS version of the node, only if the event is on node level."},
+	{ecsNamespace + ".vdc.cfgname", "keyword", "Name of the VDC in ecsbeat.yml."},
+	{ecsNamespace + ".vdc.id", "keyword", "ID of the VDC."},
+	{ecsNamespace + ".vdc.name", "keyword", "Name of the VDC."},
+	{ecsNamespace + ".rack.id", "keyword", "Rack of the node, only if the event is on node level."},
+	{ecsNamespace + ".node.status", "keyword", "Status of the node, only if the event is on node level."},
+	{ecsNamespace + ".storagepool.id", "keyword", "ID of the storage pool of the node, or IDs of all storage pools of the VDC."},
+	{ecsNamespace + ".storagepool.name", "keyword", "Name of the storage pool of the node, or names of all storage pools of the VDC."},
+	{ecsNamespace + ".served_by", "keyword", "Host the data is queried from, only if the event is on node level."},
+	{ecsNamespace + ".index", "keyword", "Index the event is routed to, only if routing is configured."},
+	{ecsNamespace + ".pipeline", "keyword", "Ingest pipeline the event is routed to, only if routing is configured."},
+}
+
+// ecsSeverities maps severities of ECS alerts and rules to event.severity
+var ecsSeverities = map[string]int{"CRITICAL": 2, "ERROR": 3, "WARNING": 4, "INFO": 6}
+
+// eventKinds are event.kind of event types, the rest are metrics
+var eventKinds = map[string]string{
+	"alert":        "alert",
+	"latestalert":  "alert",
+	alertEventType: "alert",
+	"auditevent":   "event",
+	"change":       "event",
+	"dttransition": "event",
+	"inventory":    "state",
+	"dtinfo":       "state",
+	"dtnode":       "state",
+}
+
+// ecsLayout is an eventProcessor converting events to the ecs layout
+var ecsLayout eventProcessorFunc = toECSLayout
+
+// toECSLayout returns event in Elastic Common Schema layout. Common fields
+// are mapped by ecsLayoutFields, other fields are nested under ecs_storage,
+// and alerts and audit events are classified in event.*.
+func toECSLayout(event common.MapStr) common.MapStr {
+	etype, _ := event["ecs-event-type"].(string)
+	result := common.MapStr{
+		"ecs":   map[string]interface{}{"version": ECSVersion},
+		"event": map[string]interface{}{"module": "ecsbeat", "dataset": "ecsbeat." + etype},
+	}
+	storage := make(map[string]interface{})
+	for k, v := range event {
+		switch k {
+		case "@timestamp":
+			result[k] = v
+			continue
+		case "@version", "type", "ecs-event-type":
+			continue
+		}
+		if name, ok := ecsLayoutFields[k]; ok {
+			putPath(result, strings.Split(name, "."), v)
+		} else {
+			storage[ecsStorageName(k)] = v
+		}
+	}
+	if s, ok := result[ecsNamespace].(map[string]interface{}); ok {
+		for k, v := range storage {
+			s[k] = v
+		}
+	} else if len(storage) > 0 {
+		result[ecsNamespace] = storage
+	}
+
+	e := result["event"].(map[string]interface{})
+	e["kind"] = "metric"
+	if kind, ok := eventKinds[etype]; ok {
+		e["kind"] = kind
+	}
+	switch etype {
+	case "alert", "latestalert", alertEventType:
+		severity, _ := event["severity"].(string)
+		if etype == alertEventType {
+			severity, _ = event["alert-severity"].(string)
+			e["action"] = event["alert-status"]
+		}
+		severity = strings.ToUpper(severity)
+		e["category"] = []string{"host"}
+		e["type"] = []string{"info"}
+		if n, ok := ecsSeverities[severity]; ok {
+			e["severity"] = n
+			if n <= ecsSeverities["ERROR"] {
+				e["type"] = []string{"error"}
+			}
+		}
+		if severity != "" {
+			result["log"] = map[string]interface{}{"level": strings.ToLower(severity)}
+		}
+		addMessage(result, event["description"])
+	case "auditevent":
+		service, _ := event["serviceType"].(string)
+		action, _ := event["eventType"].(string)
+		e["category"], e["type"] = auditClass(service, action)
+		if action != "" {
+			e["action"] = action
+		}
+		if user, ok := event["userId"].(string); ok && user != "" {
+			result["user"] = map[string]interface{}{"id": user}
+		}
+		addMessage(result, event["description"])
+	}
+	return result
+}
+
+// ecsStorageName is the name of field under ecs_storage, without the
+// redundant ecs- prefix of fields like ecs-tenant
+func ecsStorageName(name string) string {
+	return strings.TrimPrefix(name, "ecs-")
+}
+
+func addMessage(event common.MapStr, v interface{}) {
+	if s, ok := v.(string); ok && s != "" {
+		event["message"] = s
+	}
+}
+
+// auditClass returns event.category and event.type of an audit event by its
+// service and event type
+func auditClass(service, action string) ([]string, []string) {
+	service, action = strings.ToUpper(service), strings.ToUpper(action)
+	category := "configuration"
+	switch {
+	case strings.Contains(service, "LOGIN") || strings.Contains(service, "AUTH") ||
+		strings.Contains(action, "LOGIN") || strings.Contains(action, "LOGOUT"):
+		category = "authentication"
+	case strings.Contains(service, "USER") || strings.Contains(service, "TENANT") ||
+		strings.Contains(service, "NAMESPACE"):
+		category = "iam"
+	}
+	typ := "info"
+	switch {
+	case category == "authentication":
+		typ = "start"
+		if strings.Contains(action, "LOGOUT") {
+			typ = "end"
+		}
+	case strings.Contains(action, "CREATE") || strings.Contains(action, "ADD"):
+		typ = "creation"
+	case strings.Contains(action, "DELETE") || strings.Contains(action, "REMOVE"):
+		typ = "deletion"
+	case strings.Contains(action, "UPDATE") || strings.Contains(action, "MODIFY") || strings.Contains(action, "SET"):
+		typ = "change"
+	}
+	return []string{category}, []string{typ}
+}
@@ -0,0 +1,63 @@
+package beater
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestECSLayout ...
+func TestECSLayout(t *testing.T) {
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{
+		"VDC1": {ConfigName: "VDC1", ID: "vdc1", Name: "vdc1", NodeInfo: map[string]*Node{
+			"1.1.1.1": {ID: "n1", IP: "1.1.1.1", Name: "node1", Version: "3.0"},
+		}},
+	}}
+	alert := common.MapStr{"id": "a1", "severity": "CRITICAL", "description": "disk failed", "acknowledged": false}
+	addCommonFields(alert, cfg, "VDC1", "1.1.1.1", "alert")
+	alert["ecs-doc-id"] = "d1"
+	audit := common.MapStr{"serviceType": "TENANT", "eventType": "TENANT_CREATED", "userId": "root", "description": "created"}
+	addCommonFields(audit, cfg, "VDC1", "", "auditevent")
+	pool := common.MapStr{"id": "sp1", "capacityUtilization_ratio": 0.5, "ecs-tenant": "t1"}
+	addCommonFields(pool, cfg, "VDC1", "", "storagepools")
+
+	e := toECSLayout(alert)
+	ecs.AssertEqual(t, alert["@timestamp"], e["@timestamp"], "")
+	ecs.AssertEqual(t, map[string]interface{}{"name": "c1"}, e["organization"], "")
+	ecs.AssertEqual(t, map[string]interface{}{"ip": "1.1.1.1", "name": "node1"}, e["host"], "")
+	ecs.AssertEqual(t, map[string]interface{}{"version": ECSVersion}, e["ecs"], "")
+	ecs.AssertEqual(t, "disk failed", e["message"], "")
+	ev := e["event"].(map[string]interface{})
+	ecs.AssertEqual(t, "alert", ev["kind"], "")
+	ecs.AssertEqual(t, "ecsbeat.alert", ev["dataset"], "")
+	ecs.AssertEqual(t, 2, ev["severity"], "")
+	ecs.AssertEqual(t, []string{"error"}, ev["type"], "")
+	ecs.AssertEqual(t, "d1", ev["id"], "")
+	storage := e["ecs_storage"].(map[string]interface{})
+	ecs.AssertEqual(t, "3.0", storage["version"], "")
+	ecs.AssertEqual(t, "a1", storage["id"], "")
+	ecs.AssertEqual(t, map[string]interface{}{"cfgname": "VDC1", "id": "vdc1", "name": "vdc1"}, storage["vdc"], "")
+	_, legacy := e["ecs-customer"]
+	ecs.AssertEqual(t, false, legacy, "")
+
+	e2 := toECSLayout(audit)
+	ev = e2["event"].(map[string]interface{})
+	ecs.AssertEqual(t, "event", ev["kind"], "")
+	ecs.AssertEqual(t, []string{"iam"}, ev["category"], "")
+	ecs.AssertEqual(t, []string{"creation"}, ev["type"], "")
+	ecs.AssertEqual(t, map[string]interface{}{"id": "root"}, e2["user"], "")
+
+	e3 := toECSLayout(pool)
+	ev = e3["event"].(map[string]interface{})
+	ecs.AssertEqual(t, "metric", ev["kind"], "")
+	ecs.AssertEqual(t, "t1", e3["ecs_storage"].(map[string]interface{})["tenant"], "")
+
+	_, declared, err := ecsTemplateFields()
+	ecs.AssertEqualFatal(t, nil, err, "")
+	for _, event := range []common.MapStr{e, e2, e3} {
+		for _, name := range flattenKeys("", event) {
+			ecs.AssertEqual(t, true, inTemplate(declared, name), name)
+		}
+	}
+}
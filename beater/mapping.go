@@ -0,0 +1,205 @@
+package beater
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+type renameRule struct {
+	from, to selector
+}
+
+type castRule struct {
+	field selector
+	typ   string
+}
+
+// Mapping shapes a decoded ECS response: include/exclude fields, rename,
+// cast and finally move fields under a namespace, in that order.
+type Mapping struct {
+	include   []selector
+	exclude   []selector
+	rename    []renameRule
+	cast      []castRule
+	namespace string
+	nsFields  []selector
+}
+
+// NewMapping compiles mapping configuration of a command
+func NewMapping(c *config.Mapping) (*Mapping, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var (
+		m   Mapping
+		err error
+	)
+	if m.include, err = parseSelectors(c.Include); err != nil {
+		return nil, fmt.Errorf("include: %v", err)
+	}
+	if m.exclude, err = parseSelectors(c.Exclude); err != nil {
+		return nil, fmt.Errorf("exclude: %v", err)
+	}
+	for _, r := range c.Rename {
+		from, err := parseSelector(r.From)
+		if err != nil {
+			return nil, fmt.Errorf("rename %q: %v", r.From, err)
+		}
+		if from.hasWildcard() {
+			return nil, fmt.Errorf("rename %q: wildcards are not allowed", r.From)
+		}
+		to, err := parseSelector(r.To)
+		if err != nil {
+			return nil, fmt.Errorf("rename %q: %v", r.To, err)
+		}
+		if to.hasWildcard() {
+			return nil, fmt.Errorf("rename %q: wildcards are not allowed", r.To)
+		}
+		m.rename = append(m.rename, renameRule{from, to})
+	}
+	for _, r := range c.Cast {
+		field, err := parseSelector(r.Field)
+		if err != nil {
+			return nil, fmt.Errorf("cast %q: %v", r.Field, err)
+		}
+		switch r.Type {
+		case "long", "double", "bool", "date":
+		default:
+			return nil, fmt.Errorf("cast %q: unsupported type %q", r.Field, r.Type)
+		}
+		m.cast = append(m.cast, castRule{field, r.Type})
+	}
+	if c.Namespace != nil {
+		if m.namespace = c.Namespace.Name; m.namespace == "" {
+			return nil, fmt.Errorf("namespace: name is required")
+		}
+		if m.nsFields, err = parseSelectors(c.Namespace.Fields); err != nil {
+			return nil, fmt.Errorf("namespace: %v", err)
+		}
+	}
+	return &m, nil
+}
+
+// Apply shapes event in place, it's a no-op on nil Mapping
+func (m *Mapping) Apply(event map[string]interface{}) {
+	if m == nil {
+		return
+	}
+	if len(m.include) > 0 {
+		kept := make(map[string]interface{})
+		for _, sel := range m.include {
+			for _, p := range selectPaths(event, sel) {
+				v, _ := getPath(event, p)
+				putPath(kept, p, v)
+			}
+		}
+		for k := range event {
+			delete(event, k)
+		}
+		for k, v := range kept {
+			event[k] = v
+		}
+	}
+	for _, sel := range m.exclude {
+		for _, p := range selectPaths(event, sel) {
+			deletePath(event, p)
+		}
+	}
+	for _, r := range m.rename {
+		if v, ok := getPath(event, r.from); ok {
+			deletePath(event, r.from)
+			putPath(event, r.to, v)
+		}
+	}
+	for _, r := range m.cast {
+		for _, p := range selectPaths(event, r.field) {
+			v, _ := getPath(event, p)
+			if casted, err := castValue(v, r.typ); err == nil {
+				putPath(event, p, casted)
+			} else {
+				debugf("cast %s to %s: %v", strings.Join(p, "."), r.typ, err)
+			}
+		}
+	}
+	if m.namespace != "" {
+		moved := make(map[string]interface{})
+		if len(m.nsFields) == 0 {
+			for k, v := range event {
+				moved[k] = v
+				delete(event, k)
+			}
+		}
+		for _, sel := range m.nsFields {
+			for _, p := range selectPaths(event, sel) {
+				v, _ := getPath(event, p)
+				deletePath(event, p)
+				putPath(moved, p, v)
+			}
+		}
+		event[m.namespace] = moved
+	}
+}
+
+func castValue(v interface{}, typ string) (interface{}, error) {
+	s := strings.TrimSpace(fmt.Sprint(v))
+	if f, ok := v.(float64); ok {
+		// JSON numbers are decoded as float64, avoid exponent format
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	switch typ {
+	case "long":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		// rounded rather than truncated
+		return int64(math.Floor(f + 0.5)), nil
+	case "double":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "date":
+		t, err := parseTime(s)
+		if err != nil {
+			return nil, err
+		}
+		return common.Time(t), nil
+	}
+	return nil, fmt.Errorf("unsupported type %q", typ)
+}
+
+// layouts tried in order when parsing timestamps returned by ECS
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+}
+
+// parseTime parses well known layouts, or epoch in seconds or milliseconds
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown time format %q", s)
+	}
+	// epoch in seconds won't reach 1e11 before year 5000
+	if n >= 1e11 {
+		return time.Unix(0, n*int64(time.Millisecond)), nil
+	}
+	return time.Unix(n, 0), nil
+}
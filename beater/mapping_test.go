@@ -0,0 +1,87 @@
+package beater
+
+import (
+	"testing"
+
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestParseSelector ...
+func TestParseSelector(t *testing.T) {
+	sel, err := parseSelector("$.a.b")
+	ecs.AssertEqual(t, nil, err, "")
+	ecs.AssertEqual(t, selector{"a", "b"}, sel, "")
+	sel, err = parseSelector("$['a.b'].c")
+	ecs.AssertEqual(t, nil, err, "")
+	ecs.AssertEqual(t, selector{"a.b", "c"}, sel, "")
+	sel, err = parseSelector("disk[0-9]*")
+	ecs.AssertEqual(t, nil, err, "")
+	ecs.AssertEqual(t, selector{"disk[0-9]*"}, sel, "")
+	_, err = parseSelector("$")
+	ecs.AssertNotEqual(t, nil, err, "")
+}
+
+// TestMapping ...
+func TestMapping(t *testing.T) {
+	m, err := NewMapping(&config.Mapping{
+		Include:   []string{"$.id", "$.name", "$.*Current_*", "$.link"},
+		Exclude:   []string{"$.link"},
+		Rename:    []*config.FieldRename{{From: "$.name", To: "$.pool_name"}},
+		Cast:      []*config.FieldCast{{Field: "$.*_Space", Type: "long"}},
+		Namespace: &config.FieldNamespace{Name: "pool", Fields: []string{"$.*Current_*"}},
+	})
+	ecs.AssertEqualFatal(t, nil, err, "")
+
+	event := map[string]interface{}{
+		"id":                          "sp1",
+		"name":                        "pool1",
+		"link":                        "/x",
+		"numNodes":                    "4",
+		"diskSpaceTotalCurrent_Space": "1024",
+	}
+	m.Apply(event)
+	ecs.AssertEqual(t, map[string]interface{}{
+		"id":        "sp1",
+		"pool_name": "pool1",
+		"pool": map[string]interface{}{
+			"diskSpaceTotalCurrent_Space": int64(1024),
+		},
+	}, event, "")
+
+	var nilMapping *Mapping
+	nilMapping.Apply(event)
+}
+
+// TestNewMappingErrors ...
+func TestNewMappingErrors(t *testing.T) {
+	_, err := NewMapping(&config.Mapping{
+		Cast: []*config.FieldCast{{Field: "$.x", Type: "float"}},
+	})
+	ecs.AssertNotEqual(t, nil, err, "")
+	_, err = NewMapping(&config.Mapping{
+		Rename: []*config.FieldRename{{From: "$.*", To: "$.y"}},
+	})
+	ecs.AssertNotEqual(t, nil, err, "")
+	_, err = NewMapping(&config.Mapping{
+		Rename: []*config.FieldRename{{From: "$.x", To: "$.*"}},
+	})
+	ecs.AssertNotEqual(t, nil, err, "")
+}
+
+// TestCastLong ...
+func TestCastLong(t *testing.T) {
+	for _, c := range []struct {
+		in   interface{}
+		want int64
+	}{
+		{"1024", 1024},
+		{1.4e+09, 1400000000},
+		{"2.6", 3},
+		{2.4, 2},
+	} {
+		v, err := castValue(c.in, "long")
+		ecs.AssertEqual(t, nil, err, "")
+		ecs.AssertEqual(t, c.want, v, "")
+	}
+}
@@ -0,0 +1,176 @@
+package beater
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// NamespaceFields are added to billing events by NamespaceCache.Enrich
+var NamespaceFields = []SchemaField{
+	{"ecs-namespace-name", "keyword", "Name of the namespace."},
+	{"ecs-namespace-replication-group", "keyword", "Default replication group of the namespace."},
+	{"ecs-namespace-default-quota_bytes", "long", "Default quota of buckets in the namespace, absent if unlimited."},
+	{"ecs-namespace-admins", "keyword", "Admins owning the namespace."},
+	{"ecs-tenant", "keyword", "Tenant of the namespace, from the namespace mapping file."},
+	{"ecs-cost-center", "keyword", "Cost center of the namespace, from the namespace mapping file."},
+	{"ecs-business-unit", "keyword", "Business unit of the namespace, from the namespace mapping file."},
+}
+
+// NamespaceOwner is a row of the namespace mapping file
+type NamespaceOwner struct {
+	Tenant       string
+	CostCenter   string
+	BusinessUnit string
+}
+
+// LoadNamespaceMapping reads CSV file of namespace, tenant, cost center and
+// business unit. A header line starting with `namespace` and lines starting
+// with # are skipped.
+func LoadNamespaceMapping(path string) (map[string]NamespaceOwner, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readNamespaceMapping(f)
+}
+
+func readNamespaceMapping(r io.Reader) (map[string]NamespaceOwner, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	result := make(map[string]NamespaceOwner)
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 0 && strings.EqualFold(record[0], "namespace") {
+			continue
+		}
+		if len(record) < 2 || record[0] == "" {
+			return nil, fmt.Errorf("line %d: namespace and tenant are required", line+1)
+		}
+		record = append(record, "", "")
+		result[record[0]] = NamespaceOwner{Tenant: record[1], CostCenter: record[2], BusinessUnit: record[3]}
+	}
+}
+
+// NamespaceCache holds namespaces of a customer refreshed along with VDCs
+// and nodes, and the owners of namespaces from the mapping file
+type NamespaceCache struct {
+	mutex      sync.RWMutex
+	namespaces map[string]*ecs.Namespace
+	owners     map[string]NamespaceOwner
+}
+
+// NewNamespaceCache ...
+func NewNamespaceCache(owners map[string]NamespaceOwner) *NamespaceCache {
+	return &NamespaceCache{owners: owners}
+}
+
+// Refresh lists namespaces from the first VDC responding. Details of a
+// namespace failing to query are kept from the previous refresh.
+func (c *NamespaceCache) Refresh(client *ecs.MgmtClient, vdcs map[string]*Vdc) {
+	if c == nil {
+		return
+	}
+	for vname := range vdcs {
+		ids, err := ecs.GetNamespaceIDs(client, vname)
+		if err != nil {
+			logp.Err("namespaces: %v", err)
+			continue
+		}
+		// namespaces are replaced as a whole, never modified in place
+		c.mutex.RLock()
+		previous := c.namespaces
+		c.mutex.RUnlock()
+		namespaces := make(map[string]*ecs.Namespace, len(ids))
+		for _, id := range ids {
+			ns, err := ecs.GetNamespace(client, vname, id)
+			if err != nil {
+				logp.Err("namespace %s: %v", id, err)
+				ns = previous[id]
+				if ns == nil {
+					ns = &ecs.Namespace{ID: id, Name: id}
+				}
+			}
+			namespaces[id] = ns
+		}
+		c.mutex.Lock()
+		c.namespaces = namespaces
+		c.mutex.Unlock()
+		return
+	}
+}
+
+// IDs returns IDs of namespaces in sorted order, nil if c is nil or not refreshed yet
+func (c *NamespaceCache) IDs() []string {
+	if c == nil {
+		return nil
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.namespaces == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(c.namespaces))
+	for id := range c.namespaces {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Enrich adds NamespaceFields of the namespace of billing event
+func (c *NamespaceCache) Enrich(event map[string]interface{}) {
+	if c == nil {
+		return
+	}
+	id, _ := event["namespace"].(string)
+	if id == "" {
+		return
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if ns, ok := c.namespaces[id]; ok {
+		event["ecs-namespace-name"] = ns.Name
+		if ns.DefaultDataServicesVpool != "" {
+			event["ecs-namespace-replication-group"] = ns.DefaultDataServicesVpool
+		}
+		// default bucket quota is in GB, -1 if unlimited
+		if ns.DefaultBucketBlockSize > 0 {
+			event["ecs-namespace-default-quota_bytes"] = ns.DefaultBucketBlockSize << 30
+		}
+		var admins []string
+		for _, a := range strings.Split(ns.NamespaceAdmins+","+ns.ExternalGroupAdmins, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				admins = append(admins, a)
+			}
+		}
+		if len(admins) > 0 {
+			event["ecs-namespace-admins"] = admins
+		}
+	}
+	if owner, ok := c.owners[id]; ok {
+		event["ecs-tenant"] = owner.Tenant
+		if owner.CostCenter != "" {
+			event["ecs-cost-center"] = owner.CostCenter
+		}
+		if owner.BusinessUnit != "" {
+			event["ecs-business-unit"] = owner.BusinessUnit
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package beater
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestNamespaceMapping ...
+func TestNamespaceMapping(t *testing.T) {
+	owners, err := readNamespaceMapping(strings.NewReader(`namespace,tenant,cost_center,business_unit
+# comment
+ns1, acme, CC-100, retail
+ns2,globex
+`))
+	ecs.AssertEqualFatal(t, nil, err, "")
+	ecs.AssertEqual(t, 2, len(owners), "")
+	ecs.AssertEqual(t, NamespaceOwner{"acme", "CC-100", "retail"}, owners["ns1"], "")
+	ecs.AssertEqual(t, NamespaceOwner{Tenant: "globex"}, owners["ns2"], "")
+
+	_, err = readNamespaceMapping(strings.NewReader("ns1\n"))
+	ecs.AssertNotEqual(t, nil, err, "tenant is missing")
+}
+
+// TestNamespaceEnrich ...
+func TestNamespaceEnrich(t *testing.T) {
+	var nilCache *NamespaceCache
+	ecs.AssertEqual(t, []string(nil), nilCache.IDs(), "")
+	nilCache.Enrich(map[string]interface{}{"namespace": "ns1"})
+
+	c := NewNamespaceCache(map[string]NamespaceOwner{"ns1": {Tenant: "acme", CostCenter: "CC-100"}})
+	ecs.AssertEqual(t, []string(nil), c.IDs(), "not refreshed yet")
+	c.namespaces = map[string]*ecs.Namespace{
+		"ns2": {ID: "ns2", Name: "ns2", DefaultBucketBlockSize: -1},
+		"ns1": {ID: "ns1", Name: "ns1", DefaultDataServicesVpool: "rg1", DefaultBucketBlockSize: 2,
+			NamespaceAdmins: "alice, bob", ExternalGroupAdmins: ""},
+	}
+	ecs.AssertEqual(t, []string{"ns1", "ns2"}, c.IDs(), "")
+
+	event := map[string]interface{}{"namespace": "ns1"}
+	c.Enrich(event)
+	ecs.AssertEqual(t, "ns1", event["ecs-namespace-name"], "")
+	ecs.AssertEqual(t, "rg1", event["ecs-namespace-replication-group"], "")
+	ecs.AssertEqual(t, int64(2<<30), event["ecs-namespace-default-quota_bytes"], "")
+	ecs.AssertEqual(t, []string{"alice", "bob"}, event["ecs-namespace-admins"], "")
+	ecs.AssertEqual(t, "acme", event["ecs-tenant"], "")
+	ecs.AssertEqual(t, "CC-100", event["ecs-cost-center"], "")
+	_, ok := event["ecs-business-unit"]
+	ecs.AssertEqual(t, false, ok, "")
+
+	event = map[string]interface{}{"namespace": "ns2"}
+	c.Enrich(event)
+	_, ok = event["ecs-namespace-default-quota_bytes"]
+	ecs.AssertEqual(t, false, ok, "unlimited quota")
+	_, ok = event["ecs-tenant"]
+	ecs.AssertEqual(t, false, ok, "")
+}
@@ -0,0 +1,334 @@
+package beater
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+// maxPendingNotifications bounds notifications waiting to be sent, more are
+// dropped rather than holding up publishing
+const maxPendingNotifications = 256
+
+// maxNotifiedIDs is how many IDs of ECS alerts notified are remembered per
+// customer, since latestalert returns the same alerts every poll
+const maxNotifiedIDs = 1000
+
+const notifiedKey = "notified"
+
+// notifiedSaveInterval is how often IDs of ECS alerts newly notified are
+// persisted while events are processed, rather than once per alert
+const notifiedSaveInterval = 10 * time.Second
+
+type silence struct {
+	*config.Silence
+	start, end time.Time
+}
+
+func (s *silence) matches(n *Notification, rule string, now time.Time) bool {
+	if !s.start.IsZero() && now.Before(s.start) || !s.end.IsZero() && !now.Before(s.end) {
+		return false
+	}
+	return matchEmptyOrAny(s.Types, n.Type) &&
+		matchEmptyOrAny(s.Severities, strings.ToUpper(n.Severity)) &&
+		matchEmptyOrAny(s.Rules, rule)
+}
+
+func matchEmptyOrAny(patterns []string, name string) bool {
+	return len(patterns) == 0 || matchAny(patterns, name)
+}
+
+type customerRoutes struct {
+	routes   []*config.Route
+	silences []*silence
+	// IDs of ECS alerts notified, oldest first
+	ids  []string
+	seen map[string]bool
+}
+
+type pendingNotification struct {
+	n     *Notification
+	sinks []string
+}
+
+// Notifier sends ECS alerts and alerts raised by rules to sinks routed by
+// customer, type and severity, unless they are silenced
+type Notifier struct {
+	mutex     sync.Mutex
+	registry  *Registry
+	sinks     map[string]Sink
+	customers map[string]*customerRoutes
+	queue     chan pendingNotification
+	wg        sync.WaitGroup
+	// dirty is set once IDs of ECS alerts notified change until saved
+	dirty bool
+}
+
+// NewNotifier validates sinks and routes of customers, and loads IDs of ECS
+// alerts notified before from registry. It returns nil if there's no sink.
+func NewNotifier(c config.Config, registry *Registry) (*Notifier, error) {
+	if len(c.Sinks) == 0 {
+		return nil, nil
+	}
+	n := &Notifier{
+		registry:  registry,
+		sinks:     make(map[string]Sink),
+		customers: make(map[string]*customerRoutes),
+		queue:     make(chan pendingNotification, maxPendingNotifications),
+	}
+	for _, s := range c.Sinks {
+		if s.Name == "" {
+			return nil, fmt.Errorf("sink without name")
+		}
+		if _, ok := n.sinks[s.Name]; ok {
+			return nil, fmt.Errorf("sink %s: duplicate name", s.Name)
+		}
+		sink, err := NewSink(s)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %v", s.Name, err)
+		}
+		n.sinks[s.Name] = sink
+	}
+	for _, customer := range c.Customers {
+		cr := &customerRoutes{routes: customer.Routes, seen: make(map[string]bool)}
+		for _, r := range customer.Routes {
+			for _, s := range r.Sinks {
+				if _, ok := n.sinks[s]; !ok {
+					return nil, fmt.Errorf("%s routes: unknown sink %s", customer.CustomerName, s)
+				}
+			}
+			if err := validatePatterns(r.Types, r.Severities); err != nil {
+				return nil, fmt.Errorf("%s routes: %v", customer.CustomerName, err)
+			}
+		}
+		for _, s := range customer.Silences {
+			sl := &silence{Silence: s}
+			var err error
+			if s.Start != "" {
+				if sl.start, err = time.Parse(time.RFC3339, s.Start); err != nil {
+					return nil, fmt.Errorf("%s silences: %v", customer.CustomerName, err)
+				}
+			}
+			if s.End != "" {
+				if sl.end, err = time.Parse(time.RFC3339, s.End); err != nil {
+					return nil, fmt.Errorf("%s silences: %v", customer.CustomerName, err)
+				}
+			}
+			if err := validatePatterns(s.Types, s.Severities, s.Rules); err != nil {
+				return nil, fmt.Errorf("%s silences: %v", customer.CustomerName, err)
+			}
+			cr.silences = append(cr.silences, sl)
+		}
+		n.customers[customer.CustomerName] = cr
+	}
+	notified := make(map[string][]string)
+	registry.Get(notifiedKey, &notified)
+	for customer, ids := range notified {
+		if cr, ok := n.customers[customer]; ok {
+			cr.ids = ids
+			for _, id := range ids {
+				cr.seen[id] = true
+			}
+		}
+	}
+	return n, nil
+}
+
+// Save persists IDs of ECS alerts notified if they changed, so that alerts
+// still open are not notified again after restarts
+func (n *Notifier) Save() {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	if !n.dirty {
+		n.mutex.Unlock()
+		return
+	}
+	notified := make(map[string][]string, len(n.customers))
+	for customer, cr := range n.customers {
+		if len(cr.ids) > 0 {
+			notified[customer] = append([]string{}, cr.ids...)
+		}
+	}
+	n.dirty = false
+	n.mutex.Unlock()
+	if err := n.registry.Set(notifiedKey, notified); err != nil {
+		logp.Err("failed to save notified alerts: %v", err)
+	}
+}
+
+func validatePatterns(lists ...[]string) error {
+	for _, patterns := range lists {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// copyEvent copies top level fields of event, since the publisher may add
+// fields to it while it's being sent
+func copyEvent(event common.MapStr) map[string]interface{} {
+	result := make(map[string]interface{}, len(event))
+	for k, v := range event {
+		result[k] = v
+	}
+	return result
+}
+
+// notification tells whether event is an alert to notify, along with the
+// rule name or symptom code silences match, and ID of ECS alerts
+func notification(event common.MapStr) (n *Notification, rule, id string) {
+	etype, _ := event["ecs-event-type"].(string)
+	customer, _ := event["ecs-customer"].(string)
+	switch etype {
+	case "alert", "latestalert":
+		n = &Notification{Customer: customer, Type: etype, Event: copyEvent(event)}
+		n.Severity, _ = event["severity"].(string)
+		n.Title, _ = event["description"].(string)
+		rule, _ = event["symptomCode"].(string)
+		id, _ = event["id"].(string)
+	case alertEventType:
+		n = &Notification{Customer: customer, Type: etype, Event: copyEvent(event)}
+		n.Severity, _ = event["alert-severity"].(string)
+		rule, _ = event["alert-rule"].(string)
+		status, _ := event["alert-status"].(string)
+		n.Title = fmt.Sprintf("%s %s", rule, status)
+		if msg, ok := event["alert-message"].(string); ok {
+			n.Title += ": " + msg
+		}
+	}
+	return
+}
+
+// route returns names of sinks event is sent to at now
+func (n *Notifier) route(event common.MapStr, now time.Time) (*Notification, []string) {
+	nt, rule, id := notification(event)
+	if nt == nil {
+		return nil, nil
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	cr, ok := n.customers[nt.Customer]
+	if !ok {
+		return nil, nil
+	}
+	var sinks []string
+	added := make(map[string]bool)
+	for _, r := range cr.routes {
+		if !matchEmptyOrAny(r.Types, nt.Type) || !matchEmptyOrAny(r.Severities, strings.ToUpper(nt.Severity)) {
+			continue
+		}
+		for _, s := range r.Sinks {
+			if !added[s] {
+				added[s] = true
+				sinks = append(sinks, s)
+			}
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	for _, s := range cr.silences {
+		if s.matches(nt, rule, now) {
+			debugf("%s alert %s silenced", nt.Customer, nt.Title)
+			return nil, nil
+		}
+	}
+	if id != "" {
+		if cr.seen[id] {
+			return nil, nil
+		}
+		cr.seen[id] = true
+		cr.ids = append(cr.ids, id)
+		if len(cr.ids) > maxNotifiedIDs {
+			delete(cr.seen, cr.ids[0])
+			cr.ids = cr.ids[1:]
+		}
+		n.dirty = true
+	}
+	return nt, sinks
+}
+
+// Notify queues event to be sent if it's an alert routed to any sink
+func (n *Notifier) Notify(event common.MapStr) {
+	if n == nil {
+		return
+	}
+	nt, sinks := n.route(event, time.Now())
+	if nt == nil {
+		return
+	}
+	select {
+	case n.queue <- pendingNotification{nt, sinks}:
+	default:
+		logp.Warn("%s: too many notifications pending, dropping %s", nt.Customer, nt.Title)
+	}
+}
+
+// Start sends queued notifications until Close is called
+func (n *Notifier) Start() {
+	if n == nil {
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for p := range n.queue {
+			for _, s := range p.sinks {
+				if err := n.sinks[s].Send(p.n); err != nil {
+					logp.Err("%s: failed to notify %s: %v", p.n.Customer, s, err)
+				}
+			}
+		}
+	}()
+}
+
+// Close waits for notifications queued to be sent, no more events may be
+// notified afterwards
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	n.wg.Wait()
+}
+
+// Process forwards events of in, queuing alerts to be notified. IDs of ECS
+// alerts notified are saved every notifiedSaveInterval and once in is closed.
+func (n *Notifier) Process(done <-chan struct{}, in <-chan common.MapStr) <-chan common.MapStr {
+	if n == nil {
+		return in
+	}
+	out := make(chan common.MapStr, 1)
+	go func() {
+		defer close(out)
+		defer n.Save()
+		ticker := time.NewTicker(notifiedSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case event, ok := <-in:
+				if !ok {
+					return
+				}
+				n.Notify(event)
+				if !writeEvent(done, out, event) {
+					return
+				}
+			case <-ticker.C:
+				n.Save()
+			}
+		}
+	}()
+	return out
+}
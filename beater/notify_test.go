@@ -0,0 +1,111 @@
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestNotifierRoute ...
+func TestNotifierRoute(t *testing.T) {
+	c := config.Config{
+		Sinks: []*config.Sink{
+			{Name: "hook", Type: "webhook", URL: "http://127.0.0.1:1/"},
+			{Name: "mail", Type: "email", Host: "127.0.0.1:1", From: "a@b", To: []string{"c@d"}},
+		},
+		Customers: []*config.Customer{
+			{
+				CustomerName: "c1",
+				Routes: []*config.Route{
+					{Severities: []string{"CRITICAL"}, Sinks: []string{"hook", "mail"}},
+					{Types: []string{"ecsbeat-alert"}, Sinks: []string{"hook"}},
+				},
+				Silences: []*config.Silence{
+					{Rules: []string{"maint-*"}},
+					{Types: []string{"latestalert"}, Start: "2017-03-01T00:00:00Z", End: "2017-03-02T00:00:00Z"},
+				},
+			},
+			{CustomerName: "c2"},
+		},
+	}
+	registry, _ := NewRegistry("")
+	n, err := NewNotifier(c, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	ecsAlert := common.MapStr{"ecs-event-type": "alert", "ecs-customer": "c1", "id": "a1", "severity": "critical", "description": "disk failed"}
+	nt, sinks := n.route(ecsAlert, now)
+	ecs.AssertEqualFatal(t, true, nt != nil, "")
+	ecs.AssertEqual(t, []string{"hook", "mail"}, sinks, "")
+	ecs.AssertEqual(t, "disk failed", nt.Title, "")
+	// the same ECS alert is notified once, even after restarts
+	nt, _ = n.route(ecsAlert, now)
+	ecs.AssertEqual(t, true, nt == nil, "")
+	ecs.AssertEqual(t, false, registry.Get(notifiedKey, &map[string][]string{}), "not saved per alert")
+	n.Save()
+	restarted, err := NewNotifier(c, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	nt, _ = restarted.route(ecsAlert, now)
+	ecs.AssertEqual(t, true, nt == nil, "")
+
+	local := common.MapStr{"ecs-event-type": "ecsbeat-alert", "ecs-customer": "c1", "alert-rule": "pool-full", "alert-status": "firing", "alert-severity": "WARNING"}
+	nt, sinks = n.route(local, now)
+	ecs.AssertEqualFatal(t, true, nt != nil, "")
+	ecs.AssertEqual(t, []string{"hook"}, sinks, "")
+	ecs.AssertEqual(t, "pool-full firing", nt.Title, "")
+	// renotified local alerts are sent again
+	nt, _ = n.route(local, now)
+	ecs.AssertEqual(t, true, nt != nil, "")
+
+	// not routed
+	nt, _ = n.route(common.MapStr{"ecs-event-type": "alert", "ecs-customer": "c1", "id": "a2", "severity": "WARNING"}, now)
+	ecs.AssertEqual(t, true, nt == nil, "")
+	nt, _ = n.route(common.MapStr{"ecs-event-type": "alert", "ecs-customer": "c2", "id": "a3", "severity": "CRITICAL"}, now)
+	ecs.AssertEqual(t, true, nt == nil, "")
+	nt, _ = n.route(common.MapStr{"ecs-event-type": "nodes", "ecs-customer": "c1"}, now)
+	ecs.AssertEqual(t, true, nt == nil, "")
+
+	// silenced by rule name, and by type within a time range
+	local["alert-rule"] = "maint-window"
+	nt, _ = n.route(local, now)
+	ecs.AssertEqual(t, true, nt == nil, "")
+	latest := common.MapStr{"ecs-event-type": "latestalert", "ecs-customer": "c1", "id": "a4", "severity": "CRITICAL"}
+	nt, _ = n.route(latest, now)
+	ecs.AssertEqual(t, true, nt == nil, "")
+	nt, _ = n.route(latest, now.Add(24*time.Hour))
+	ecs.AssertEqual(t, true, nt != nil, "")
+
+	c.Customers[0].Routes[0].Sinks = []string{"pager"}
+	_, err = NewNotifier(c, registry)
+	ecs.AssertNotEqual(t, nil, err, "")
+}
+
+// TestNotifierProcess ...
+func TestNotifierProcess(t *testing.T) {
+	c := config.Config{
+		Sinks:     []*config.Sink{{Name: "hook", Type: "webhook", URL: "http://127.0.0.1:1/"}},
+		Customers: []*config.Customer{{CustomerName: "c1", Routes: []*config.Route{{Sinks: []string{"hook"}}}}},
+	}
+	registry, _ := NewRegistry("")
+	n, err := NewNotifier(c, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+
+	done, in := make(chan struct{}), make(chan common.MapStr, 3)
+	defer close(done)
+	for _, id := range []string{"a1", "a2", "a1"} {
+		in <- common.MapStr{"ecs-event-type": "latestalert", "ecs-customer": "c1", "id": id, "severity": "CRITICAL"}
+	}
+	close(in)
+	var count int
+	for range n.Process(done, in) {
+		count++
+	}
+	ecs.AssertEqual(t, 3, count, "")
+	// saved once in is closed
+	var notified map[string][]string
+	ecs.AssertEqual(t, true, registry.Get(notifiedKey, &notified), "")
+	ecs.AssertEqual(t, []string{"a1", "a2"}, notified["c1"], "")
+}
@@ -0,0 +1,145 @@
+package beater
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+// unitFunc queries one VDC or node of a customer for a command, it returns
+// false if done is closed
+type unitFunc func() (bool, error)
+
+// Pool bounds units of work running at the same time, in total and per
+// customer. A unit takes a slot of its customer before a slot of the pool, so
+// a customer with many VDCs and nodes never holds more than its own limit of
+// the pool, and customers waiting for the pool are served in turn.
+type Pool struct {
+	slots       chan struct{}
+	perCustomer int
+	mutex       sync.Mutex
+	limits      map[string]int
+	customers   map[string]chan struct{}
+}
+
+// NewPool validates limits of concurrency
+func NewPool(c config.Config) (*Pool, error) {
+	if c.Concurrency.Workers <= 0 {
+		return nil, fmt.Errorf("concurrency: workers must be positive")
+	}
+	if c.Concurrency.PerCustomer <= 0 {
+		return nil, fmt.Errorf("concurrency: percustomer must be positive")
+	}
+	p := &Pool{
+		slots:       make(chan struct{}, c.Concurrency.Workers),
+		perCustomer: c.Concurrency.PerCustomer,
+		limits:      make(map[string]int),
+		customers:   make(map[string]chan struct{}),
+	}
+	for _, customer := range c.Customers {
+		if customer.Concurrency < 0 {
+			return nil, fmt.Errorf("%s concurrency: must not be negative", customer.CustomerName)
+		}
+		if customer.Concurrency > 0 {
+			p.limits[customer.CustomerName] = customer.Concurrency
+		}
+	}
+	return p, nil
+}
+
+func (p *Pool) customer(name string) chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c, ok := p.customers[name]
+	if !ok {
+		limit, ok := p.limits[name]
+		if !ok {
+			limit = p.perCustomer
+		}
+		c = make(chan struct{}, limit)
+		p.customers[name] = c
+	}
+	return c
+}
+
+// acquire waits for a slot of customer and one of the pool, it returns false
+// if done is closed first
+func (p *Pool) acquire(done <-chan struct{}, customer chan struct{}) bool {
+	select {
+	case <-done:
+		return false
+	case customer <- struct{}{}:
+	}
+	select {
+	case <-done:
+		<-customer
+		return false
+	case p.slots <- struct{}{}:
+		return true
+	}
+}
+
+func (p *Pool) release(customer chan struct{}) {
+	<-p.slots
+	<-customer
+}
+
+// runUnit runs u of customer, a unit panicking is taken as done without error
+// so that the others still run
+func runUnit(customer string, u unitFunc) (torun bool, err error) {
+	torun = true
+	defer logp.Recover(fmt.Sprintf("recovered from panic while fetching %s", customer))
+	return u()
+}
+
+// Run runs units of customer concurrently within limits and waits for them to
+// finish. It returns false if done is closed, and the first error of units.
+// Units run one by one if p is nil, a unit failing doesn't stop the rest
+// either way.
+func (p *Pool) Run(done <-chan struct{}, customer string, units []unitFunc) (bool, error) {
+	if p == nil {
+		var first error
+		for _, u := range units {
+			torun, err := runUnit(customer, u)
+			if first == nil {
+				first = err
+			}
+			if !torun {
+				return false, first
+			}
+		}
+		return true, first
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		torun = true
+		first error
+	)
+	slots := p.customer(customer)
+	for _, u := range units {
+		if !p.acquire(done, slots) {
+			mutex.Lock()
+			torun = false
+			mutex.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(u unitFunc) {
+			defer wg.Done()
+			defer p.release(slots)
+			ok, err := runUnit(customer, u)
+			mutex.Lock()
+			defer mutex.Unlock()
+			torun = torun && ok
+			if first == nil {
+				first = err
+			}
+		}(u)
+	}
+	wg.Wait()
+	return torun, first
+}
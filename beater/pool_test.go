@@ -0,0 +1,145 @@
+package beater
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestPool ...
+func TestPool(t *testing.T) {
+	p, err := NewPool(config.Config{
+		Concurrency: config.Concurrency{Workers: 3, PerCustomer: 1},
+		Customers:   []*config.Customer{{CustomerName: "slow", Concurrency: 2}},
+	})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	done := make(chan struct{})
+
+	// slow customer holds both of its slots until released
+	var (
+		mutex   sync.Mutex
+		running int
+		max     int
+	)
+	started, release := make(chan struct{}, 4), make(chan struct{})
+	slow := make([]unitFunc, 4)
+	for i := range slow {
+		slow[i] = func() (bool, error) {
+			mutex.Lock()
+			if running++; running > max {
+				max = running
+			}
+			mutex.Unlock()
+			started <- struct{}{}
+			<-release
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			return true, nil
+		}
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := p.Run(done, "slow", slow)
+		result <- err
+	}()
+	<-started
+	<-started
+
+	// another customer still gets the pool, one unit at a time
+	var fast []unitFunc
+	var count, fastRunning, fastMax int
+	for i := 0; i < 3; i++ {
+		fast = append(fast, func() (bool, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			count++
+			if fastRunning++; fastRunning > fastMax {
+				fastMax = fastRunning
+			}
+			fastRunning--
+			return true, errors.New("failed")
+		})
+	}
+	torun, err := p.Run(done, "fast", fast)
+	ecs.AssertEqual(t, true, torun, "")
+	ecs.AssertNotEqual(t, nil, err, "")
+	ecs.AssertEqual(t, 3, count, "")
+	ecs.AssertEqual(t, 1, fastMax, "")
+
+	close(release)
+	ecs.AssertEqual(t, nil, <-result, "")
+	ecs.AssertEqual(t, 2, max, "")
+
+	// units waiting for the pool are not run once done is closed
+	p, err = NewPool(config.Config{Concurrency: config.Concurrency{Workers: 1, PerCustomer: 1}})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	started, release = make(chan struct{}), make(chan struct{})
+	go p.Run(done, "slow", []unitFunc{func() (bool, error) {
+		started <- struct{}{}
+		<-release
+		return true, nil
+	}})
+	<-started
+	close(done)
+	var ran bool
+	torun, _ = p.Run(done, "other", []unitFunc{func() (bool, error) { ran = true; return true, nil }})
+	close(release)
+	ecs.AssertEqual(t, false, torun, "")
+	ecs.AssertEqual(t, false, ran, "")
+
+	_, err = NewPool(config.Config{Concurrency: config.Concurrency{Workers: 0, PerCustomer: 1}})
+	ecs.AssertNotEqual(t, nil, err, "")
+}
+
+// TestPoolNil ...
+func TestPoolNil(t *testing.T) {
+	var p *Pool
+	var ran int
+	units := []unitFunc{
+		func() (bool, error) { ran++; return true, nil },
+		func() (bool, error) { ran++; return true, errors.New("failed") },
+		func() (bool, error) { ran++; return true, nil },
+	}
+	torun, err := p.Run(make(chan struct{}), "c1", units)
+	ecs.AssertEqual(t, true, torun, "")
+	ecs.AssertNotEqual(t, nil, err, "")
+	ecs.AssertEqual(t, 3, ran, "units after a failing one still run")
+
+	// units stop once done is closed
+	ran = 0
+	torun, _ = p.Run(make(chan struct{}), "c1", []unitFunc{
+		func() (bool, error) { ran++; return false, nil },
+		func() (bool, error) { ran++; return true, nil },
+	})
+	ecs.AssertEqual(t, false, torun, "")
+	ecs.AssertEqual(t, 1, ran, "")
+}
+
+// TestPoolPanic ...
+func TestPoolPanic(t *testing.T) {
+	p, err := NewPool(config.Config{Concurrency: config.Concurrency{Workers: 1, PerCustomer: 1}})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	var ran bool
+	torun, err := p.Run(make(chan struct{}), "c1", []unitFunc{
+		func() (bool, error) { panic("unit") },
+		func() (bool, error) { ran = true; return true, nil },
+	})
+	ecs.AssertEqual(t, true, torun, "")
+	ecs.AssertEqual(t, nil, err, "")
+	ecs.AssertEqual(t, true, ran, "slots of the panicking unit are released")
+
+	// same without pool
+	ran = false
+	var nilPool *Pool
+	torun, err = nilPool.Run(make(chan struct{}), "c1", []unitFunc{
+		func() (bool, error) { panic("unit") },
+		func() (bool, error) { ran = true; return true, nil },
+	})
+	ecs.AssertEqual(t, true, torun, "")
+	ecs.AssertEqual(t, nil, err, "")
+	ecs.AssertEqual(t, true, ran, "")
+}
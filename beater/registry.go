@@ -0,0 +1,87 @@
+package beater
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Registry persists state of ecsbeat in a local JSON file, so that it
+// survives restarts. Each user of the registry owns a top level key.
+type Registry struct {
+	path  string
+	mutex sync.Mutex
+	state map[string]json.RawMessage
+}
+
+// NewRegistry loads registry from path, a missing file is an empty registry.
+// Registry with empty path keeps state in memory only.
+func NewRegistry(path string) (*Registry, error) {
+	r := &Registry{path: path, state: make(map[string]json.RawMessage)}
+	if path == "" {
+		return r, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&r.state); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get decodes state of key into v, returns false if key doesn't exist
+func (r *Registry) Get(key string, v interface{}) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	raw, ok := r.state[key]
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		debugf("registry key %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// Set replaces state of key with v and writes the registry file
+func (r *Registry) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state[key] = raw
+	return r.save()
+}
+
+// save writes to a temporary file first, so that the registry file is
+// never left half written
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0750); err != nil {
+		return err
+	}
+	tmp := r.path + ".new"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(f).Encode(r.state); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
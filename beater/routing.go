@@ -0,0 +1,170 @@
+package beater
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+// indexGroups are {group} of event types in routing templates, the rest are
+// metrics
+var indexGroups = map[string]string{
+	"nsbilling":           "billing",
+	"nsbillingsample":     "billing",
+	"bucketbilling":       "billing",
+	"bucketbillingsample": "billing",
+	"alert":               "alerts",
+	"latestalert":         "alerts",
+	alertEventType:        "alerts",
+	"auditevent":          "events",
+	"change":              "events",
+	"dttransition":        "events",
+	"inventory":           "events",
+}
+
+// derivedSources are command types of events derived from dtinfo
+var derivedSources = map[string]string{
+	"dtbalance":    "dtinfo",
+	"dtnode":       "dtinfo",
+	"dtsummary":    "dtinfo",
+	"dttransition": "dtinfo",
+}
+
+// sourceType returns the type of the command event is derived from, or its
+// own type. Alerts of rules follow the event they are raised on, forecasts
+// the capacity command, and disk count changes the disks command.
+func sourceType(event common.MapStr) string {
+	etype, _ := event["ecs-event-type"].(string)
+	switch etype {
+	case alertEventType:
+		if s, ok := event["alert-source-type"].(string); ok {
+			return sourceType(common.MapStr{"ecs-event-type": s})
+		}
+	case "forecast":
+		if s, ok := event["forecast-source-type"].(string); ok {
+			return s
+		}
+	case "change":
+		if event["change-type"] == "disk_count_changed" {
+			return "disks"
+		}
+	}
+	if s, ok := derivedSources[etype]; ok {
+		return s
+	}
+	return etype
+}
+
+func indexGroup(etype string) string {
+	if g, ok := indexGroups[etype]; ok {
+		return g
+	}
+	return "metrics"
+}
+
+// renderRouting fills {customer}, {type} and {group} into template t. Values
+// are lower cased and characters not allowed in index names are replaced by
+// -, as Elasticsearch requires.
+func renderRouting(t, customer, etype string) string {
+	s := strings.NewReplacer("{customer}", customer, "{type}", etype, "{group}", indexGroup(etype)).Replace(t)
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, s)
+}
+
+func validateRouting(r config.Routing) error {
+	for _, t := range []string{r.Index, r.Pipeline} {
+		s := strings.NewReplacer("{customer}", "", "{type}", "", "{group}", "").Replace(t)
+		if strings.ContainsAny(s, "{}") {
+			return fmt.Errorf("unknown placeholder in %q, must be {customer}, {type} or {group}", t)
+		}
+	}
+	return nil
+}
+
+// Router sets ecs-index and ecs-pipeline of events by their customer and
+// type, so that the Elasticsearch output can pick index and pipeline with
+// %{[ecs-index]} and %{[ecs-pipeline]}
+type Router struct {
+	global    config.Routing
+	customers map[string]config.Routing
+	types     map[string]config.Routing
+}
+
+// NewRouter validates routing templates, it returns nil if there's none
+func NewRouter(c config.Config) (*Router, error) {
+	r := &Router{
+		global:    c.Routing,
+		customers: make(map[string]config.Routing),
+		types:     make(map[string]config.Routing),
+	}
+	empty := config.Routing{}
+	configured := c.Routing != empty
+	if err := validateRouting(c.Routing); err != nil {
+		return nil, fmt.Errorf("routing: %v", err)
+	}
+	for _, customer := range c.Customers {
+		if customer.Routing == empty {
+			continue
+		}
+		if err := validateRouting(customer.Routing); err != nil {
+			return nil, fmt.Errorf("%s routing: %v", customer.CustomerName, err)
+		}
+		r.customers[customer.CustomerName] = customer.Routing
+		configured = true
+	}
+	for _, cmd := range c.Commands {
+		if !cmd.Enabled || cmd.Routing == empty {
+			continue
+		}
+		if err := validateRouting(cmd.Routing); err != nil {
+			return nil, fmt.Errorf("%s routing: %v", cmd.Type, err)
+		}
+		r.types[cmd.Type] = cmd.Routing
+		configured = true
+	}
+	if !configured {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// Route sets ecs-index and ecs-pipeline of event from the first non empty
+// template of its command type, its customer, and ecsbeat. Derived events
+// take routing of the command they are derived from, see sourceType.
+func (r *Router) Route(event common.MapStr) common.MapStr {
+	etype, _ := event["ecs-event-type"].(string)
+	customer, _ := event["ecs-customer"].(string)
+	index, pipeline := r.global.Index, r.global.Pipeline
+	for _, o := range []config.Routing{r.customers[customer], r.types[sourceType(event)]} {
+		if o.Index != "" {
+			index = o.Index
+		}
+		if o.Pipeline != "" {
+			pipeline = o.Pipeline
+		}
+	}
+	if index != "" {
+		event["ecs-index"] = renderRouting(index, customer, etype)
+	}
+	if pipeline != "" {
+		event["ecs-pipeline"] = renderRouting(pipeline, customer, etype)
+	}
+	return event
+}
+
+// Process forwards events of in with routing fields set
+func (r *Router) Process(done <-chan struct{}, in <-chan common.MapStr) <-chan common.MapStr {
+	if r == nil {
+		return in
+	}
+	return filterEvents(done, in, eventProcessorFunc(r.Route))
+}
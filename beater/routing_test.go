@@ -0,0 +1,89 @@
+package beater
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestRouter ...
+func TestRouter(t *testing.T) {
+	c := config.Config{
+		Routing: config.Routing{Index: "ecsbeat-{customer}-{group}", Pipeline: "ecsbeat-{group}"},
+		Customers: []*config.Customer{
+			{CustomerName: "Acme Corp", Routing: config.Routing{Index: "acme-{type}"}},
+			{CustomerName: "c2"},
+		},
+		Commands: []*config.Command{
+			{Type: "auditevent", Enabled: true, Routing: config.Routing{Pipeline: "audit"}},
+			{Type: "nodes", Enabled: false, Routing: config.Routing{Index: "ignored"}},
+		},
+	}
+	r, err := NewRouter(c)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	ecs.AssertEqualFatal(t, true, r != nil, "")
+
+	event := r.Route(common.MapStr{"ecs-event-type": "nsbilling", "ecs-customer": "c2"})
+	ecs.AssertEqual(t, "ecsbeat-c2-billing", event["ecs-index"], "")
+	ecs.AssertEqual(t, "ecsbeat-billing", event["ecs-pipeline"], "")
+
+	// customer overrides index only, lower cased and sanitized
+	event = r.Route(common.MapStr{"ecs-event-type": "nodes", "ecs-customer": "Acme Corp"})
+	ecs.AssertEqual(t, "acme-nodes", event["ecs-index"], "")
+	ecs.AssertEqual(t, "ecsbeat-metrics", event["ecs-pipeline"], "")
+
+	// command overrides customer
+	event = r.Route(common.MapStr{"ecs-event-type": "auditevent", "ecs-customer": "c2"})
+	ecs.AssertEqual(t, "ecsbeat-c2-events", event["ecs-index"], "")
+	ecs.AssertEqual(t, "audit", event["ecs-pipeline"], "")
+
+	ecs.AssertEqual(t, "ecsbeat-acme-corp-alerts", renderRouting("ecsbeat-{customer}-{group}", "Acme Corp", alertEventType), "")
+}
+
+// TestNewRouter ...
+func TestNewRouter(t *testing.T) {
+	r, err := NewRouter(config.Config{Customers: []*config.Customer{{CustomerName: "c1"}}})
+	ecs.AssertEqual(t, nil, err, "")
+	ecs.AssertEqual(t, true, r == nil, "")
+
+	_, err = NewRouter(config.Config{Routing: config.Routing{Index: "ecsbeat-{tenant}"}})
+	ecs.AssertNotEqual(t, nil, err, "")
+	_, err = NewRouter(config.Config{Customers: []*config.Customer{{CustomerName: "c1", Routing: config.Routing{Pipeline: "p-{type"}}}})
+	ecs.AssertNotEqual(t, nil, err, "")
+}
+
+// TestRouteDerived ...
+func TestRouteDerived(t *testing.T) {
+	r, err := NewRouter(config.Config{
+		Routing: config.Routing{Index: "ecsbeat-{type}"},
+		Commands: []*config.Command{
+			{Type: "dtinfo", Enabled: true, Routing: config.Routing{Index: "dt-{type}", Pipeline: "dt"}},
+			{Type: "storagepools", Enabled: true, Routing: config.Routing{Index: "capacity-{type}"}},
+			{Type: "disks", Enabled: true, Routing: config.Routing{Index: "disks-{type}"}},
+		},
+	})
+	ecs.AssertEqualFatal(t, nil, err, "")
+
+	for _, c := range []struct {
+		event common.MapStr
+		index string
+	}{
+		{common.MapStr{"ecs-event-type": "dtsummary"}, "dt-dtsummary"},
+		{common.MapStr{"ecs-event-type": "dttransition"}, "dt-dttransition"},
+		{common.MapStr{"ecs-event-type": "forecast", "forecast-source-type": "storagepools"}, "capacity-forecast"},
+		{common.MapStr{"ecs-event-type": "forecast", "forecast-source-type": "localzone"}, "ecsbeat-forecast"},
+		{common.MapStr{"ecs-event-type": "change", "change-type": "disk_count_changed"}, "disks-change"},
+		{common.MapStr{"ecs-event-type": "change", "change-type": "vdc_renamed"}, "ecsbeat-change"},
+		{common.MapStr{"ecs-event-type": alertEventType, "alert-source-type": "dtnode"}, "dt-ecsbeat-alert"},
+	} {
+		event := r.Route(c.event)
+		ecs.AssertEqual(t, c.index, event["ecs-index"], "")
+	}
+
+	// no pipeline unless a template is set
+	event := r.Route(common.MapStr{"ecs-event-type": "nodes"})
+	_, ok := event["ecs-pipeline"]
+	ecs.AssertEqual(t, false, ok, "")
+}
@@ -0,0 +1,366 @@
+package beater
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+const rulesKey = "rules"
+
+// maxRuleStateAge is how long states of subjects no longer seen are kept,
+// unless they are firing
+const maxRuleStateAge = 7 * 24 * time.Hour
+
+// alertEventType is the event type of alerts raised by rules
+const alertEventType = "ecsbeat-alert"
+
+// RuleAlertFields are fields of ecsbeat-alert events besides common fields
+var RuleAlertFields = []SchemaField{
+	{"alert-rule", "keyword", "Name of the rule."},
+	{"alert-status", "keyword", "firing or resolved."},
+	{"alert-severity", "keyword", "Severity of the rule."},
+	{"alert-message", "text", "Message of the rule."},
+	{"alert-id", "keyword", "ID of the alert, same for its firing and resolved events."},
+	{"alert-subject", "keyword", "Customer, VDC, node and key fields of the subject, separated by slashes."},
+	{"alert-source-type", "keyword", "Type of the event the rule is evaluated against."},
+	{"alert-field", "keyword", "Field compared by the rule."},
+	{"alert-op", "keyword", "Comparison operator of the rule."},
+	{"alert-mode", "keyword", "Whether the value, delta or rate of the field is compared."},
+	{"alert-threshold", "keyword", "Value the field is compared to."},
+	{"alert-value", "double", "Value compared, if numeric."},
+	{"alert-value-text", "keyword", "Value compared, if not numeric."},
+	{"alert-since", "date", "Time the condition started to hold."},
+	{"alert-renotified", "boolean", "Whether the firing event repeats an earlier one."},
+	{"alert-duration-seconds", "double", "Seconds the alert was firing, only if resolved."},
+}
+
+// common fields copied from the event an alert is raised by
+var alertSourceFields = func() []string {
+	var names []string
+	for _, f := range CommonFields {
+		switch f.Name {
+		case "@version", "type", "ecs-collected-at", "ecs-event-type", "ecs-doc-id", "ecs-served-by", "ecs-index", "ecs-pipeline":
+		default:
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}()
+
+var ruleOps = map[string]bool{">": true, ">=": true, "<": true, "<=": true, "==": true, "!=": true}
+
+type rule struct {
+	*config.Rule
+	field    selector
+	key      []selector
+	number   float64
+	isNumber bool
+	text     string
+}
+
+// compare tells whether v holds the condition of the rule, v is either
+// float64 or string
+func (r *rule) compare(v interface{}) bool {
+	if f, ok := v.(float64); ok && r.isNumber {
+		switch r.Op {
+		case ">":
+			return f > r.number
+		case ">=":
+			return f >= r.number
+		case "<":
+			return f < r.number
+		case "<=":
+			return f <= r.number
+		case "==":
+			return f == r.number
+		default:
+			return f != r.number
+		}
+	}
+	s := fmt.Sprint(v)
+	switch r.Op {
+	case "==":
+		return s == r.text
+	case "!=":
+		return s != r.text
+	}
+	return false
+}
+
+type ruleState struct {
+	// Since is when the condition started to hold, zero if it doesn't
+	Since time.Time `json:"since"`
+	// FiredAt is zero unless the rule is firing for the subject
+	FiredAt  time.Time `json:"fired_at"`
+	Notified time.Time `json:"notified"`
+	Prev     float64   `json:"prev"`
+	PrevT    time.Time `json:"prev_t"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Rules evaluates rules against every event published, and raises
+// ecsbeat-alert events when they fire or resolve. States of rules are saved
+// in registry whenever an alert is raised, so that alerts firing before a
+// restart are neither repeated nor lost.
+type Rules struct {
+	mutex    sync.Mutex
+	registry *Registry
+	rules    []*rule
+	states   map[string]*ruleState
+}
+
+// NewRules validates rules, it returns nil if there's no rule
+func NewRules(rules []*config.Rule, registry *Registry) (*Rules, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Rules{registry: registry, states: make(map[string]*ruleState)}
+	names := make(map[string]bool)
+	for _, c := range rules {
+		if c.Name == "" {
+			return nil, fmt.Errorf("rule without name")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", c.Name)
+		}
+		names[c.Name] = true
+		if c.Severity == "" {
+			c.Severity = "WARNING"
+		}
+		if c.Mode == "" {
+			c.Mode = "value"
+		}
+		if !ruleOps[c.Op] {
+			return nil, fmt.Errorf("rule %s: unknown op %q", c.Name, c.Op)
+		}
+		if c.Mode != "value" && c.Mode != "delta" && c.Mode != "rate" {
+			return nil, fmt.Errorf("rule %s: unknown mode %q", c.Name, c.Mode)
+		}
+		field, err := parseSelector(c.Field)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s field: %v", c.Name, err)
+		}
+		if field.hasWildcard() {
+			return nil, fmt.Errorf("rule %s field: wildcards are not allowed", c.Name)
+		}
+		keys := c.Key
+		if keys == nil {
+			keys = []string{"id"}
+		}
+		key, err := parseSelectors(keys)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s key: %v", c.Name, err)
+		}
+		for _, p := range append(append([]string{}, c.Customers...), c.Types...) {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("rule %s: %v", c.Name, err)
+			}
+		}
+		rl := &rule{Rule: c, field: field, key: key, text: fmt.Sprint(c.Value)}
+		rl.number, rl.isNumber = toFloat(c.Value)
+		if !rl.isNumber && (c.Mode != "value" || (c.Op != "==" && c.Op != "!=")) {
+			return nil, fmt.Errorf("rule %s: %s %s needs a numeric value", c.Name, c.Mode, c.Op)
+		}
+		r.rules = append(r.rules, rl)
+	}
+	registry.Get(rulesKey, &r.states)
+	return r, nil
+}
+
+// subject identifies what event describes for rl
+func (rl *rule) subject(event map[string]interface{}) string {
+	parts := []string{
+		fmt.Sprint(event["ecs-customer"]),
+		fmt.Sprint(event["ecs-vdc-cfgname"]),
+		fmt.Sprint(event["ecs-node-ip"]),
+	}
+	for _, k := range rl.key {
+		v, _ := getPath(event, k)
+		parts = append(parts, fmt.Sprint(v))
+	}
+	return strings.Join(parts, "/")
+}
+
+// Evaluate returns alerts raised by event, received at now
+func (r *Rules) Evaluate(event common.MapStr, now time.Time) []common.MapStr {
+	if r == nil {
+		return nil
+	}
+	etype, _ := event["ecs-event-type"].(string)
+	if etype == alertEventType {
+		return nil
+	}
+	customer, _ := event["ecs-customer"].(string)
+	at := now
+	if ts, ok := event["@timestamp"].(common.Time); ok {
+		at = time.Time(ts)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var alerts []common.MapStr
+	for _, rl := range r.rules {
+		if len(rl.Types) > 0 && !matchAny(rl.Types, etype) {
+			continue
+		}
+		if len(rl.Customers) > 0 && !matchAny(rl.Customers, customer) {
+			continue
+		}
+		raw, ok := getPath(event, rl.field)
+		if !ok {
+			continue
+		}
+		subject := rl.subject(event)
+		key := rl.Name + "/" + subject
+		st, ok := r.states[key]
+		if !ok {
+			st = &ruleState{}
+			r.states[key] = st
+		}
+		st.LastSeen = at
+
+		var value interface{} = fmt.Sprint(raw)
+		if f, ok := toFloat(raw); ok {
+			value = f
+		}
+		if rl.Mode != "value" {
+			f, ok := value.(float64)
+			if !ok {
+				continue
+			}
+			prev, prevT := st.Prev, st.PrevT
+			st.Prev, st.PrevT = f, at
+			if prevT.IsZero() || !at.After(prevT) {
+				continue
+			}
+			value = f - prev
+			if rl.Mode == "rate" {
+				value = (f - prev) / at.Sub(prevT).Seconds()
+			}
+		}
+
+		if !rl.compare(value) {
+			st.Since = time.Time{}
+			if !st.FiredAt.IsZero() {
+				alert := r.alert(rl, event, subject, st, value, at, now, "resolved")
+				alert["alert-duration-seconds"] = at.Sub(st.FiredAt).Seconds()
+				alerts = append(alerts, alert)
+				st.FiredAt, st.Notified = time.Time{}, time.Time{}
+			}
+			continue
+		}
+		if st.Since.IsZero() {
+			st.Since = at
+		}
+		switch {
+		case st.FiredAt.IsZero() && at.Sub(st.Since) >= rl.For:
+			st.FiredAt, st.Notified = at, at
+			alerts = append(alerts, r.alert(rl, event, subject, st, value, at, now, "firing"))
+		case !st.FiredAt.IsZero() && rl.Renotify > 0 && at.Sub(st.Notified) >= rl.Renotify:
+			st.Notified = at
+			alert := r.alert(rl, event, subject, st, value, at, now, "firing")
+			alert["alert-renotified"] = true
+			alerts = append(alerts, alert)
+		}
+	}
+	if len(alerts) > 0 {
+		r.save()
+	}
+	return alerts
+}
+
+func (r *Rules) alert(rl *rule, event common.MapStr, subject string, st *ruleState, value interface{}, at, now time.Time, status string) common.MapStr {
+	id := docID(rl.Name, subject, st.FiredAt.UTC().Format(time.RFC3339Nano))
+	alert := common.MapStr{
+		"@version":          "1.0",
+		"@timestamp":        common.Time(at),
+		"type":              "ecsbeat",
+		"ecs-collected-at":  common.Time(now),
+		"ecs-event-type":    alertEventType,
+		"alert-rule":        rl.Name,
+		"alert-status":      status,
+		"alert-severity":    rl.Severity,
+		"alert-id":          id,
+		"alert-subject":     subject,
+		"alert-source-type": event["ecs-event-type"],
+		"alert-field":       rl.Field,
+		"alert-op":          rl.Op,
+		"alert-mode":        rl.Mode,
+		"alert-threshold":   rl.text,
+	}
+	if !st.Since.IsZero() {
+		alert["alert-since"] = common.Time(st.Since)
+	}
+	addNonEmpty(alert, "alert-message", rl.Message)
+	if f, ok := value.(float64); ok {
+		alert["alert-value"] = f
+	} else {
+		alert["alert-value-text"] = value
+	}
+	for _, name := range alertSourceFields {
+		if v, ok := event[name]; ok {
+			alert[name] = v
+		}
+	}
+	// a firing event notified again overwrites the previous one
+	alert["ecs-doc-id"] = docID(fmt.Sprint(event["ecs-customer"]), alertEventType, id, status)
+	return alert
+}
+
+// save persists states of rules, states of subjects not seen for
+// maxRuleStateAge are dropped unless they are firing
+func (r *Rules) save() {
+	var latest time.Time
+	for _, st := range r.states {
+		if st.LastSeen.After(latest) {
+			latest = st.LastSeen
+		}
+	}
+	for k, st := range r.states {
+		if st.FiredAt.IsZero() && latest.Sub(st.LastSeen) > maxRuleStateAge {
+			delete(r.states, k)
+		}
+	}
+	if err := r.registry.Set(rulesKey, r.states); err != nil {
+		logp.Err("failed to save states of rules: %v", err)
+	}
+}
+
+// Save persists states of rules
+func (r *Rules) Save() {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.save()
+}
+
+// Process forwards events of in, each followed by alerts it raises
+func (r *Rules) Process(done <-chan struct{}, in <-chan common.MapStr) <-chan common.MapStr {
+	if r == nil {
+		return in
+	}
+	out := make(chan common.MapStr, 1)
+	go func() {
+		defer close(out)
+		for event := range in {
+			// evaluated before forwarding, as stages downstream modify event
+			alerts := r.Evaluate(event, time.Now())
+			if !writeEvent(done, out, event) {
+				return
+			}
+			if !writeEvents(done, out, alerts) {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,137 @@
+package beater
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+func poolEvent(id string, ratio float64, t time.Time) common.MapStr {
+	return common.MapStr{
+		"@timestamp":                common.Time(t),
+		"ecs-customer":              "c1",
+		"ecs-vdc-cfgname":           "VDC1",
+		"ecs-event-type":            "storagepools",
+		"id":                        id,
+		"capacityUtilization_ratio": ratio,
+	}
+}
+
+// TestRules ...
+func TestRules(t *testing.T) {
+	registry, _ := NewRegistry("")
+	rules, err := NewRules([]*config.Rule{
+		{Name: "pool-full", Types: []string{"storagepools"}, Field: "capacityUtilization_ratio", Op: ">", Value: 0.85, For: 10 * time.Minute, Renotify: time.Hour},
+	}, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	ecs.AssertEqual(t, 0, len(rules.Evaluate(poolEvent("sp1", 0.9, now), now)), "")
+	// other subjects and event types are apart
+	ecs.AssertEqual(t, 0, len(rules.Evaluate(poolEvent("sp2", 0.9, now.Add(10*time.Minute)), now)), "")
+	e := poolEvent("sp1", 0.9, now)
+	e["ecs-event-type"] = "localzone"
+	ecs.AssertEqual(t, 0, len(rules.Evaluate(e, now)), "")
+
+	// fires once the condition holds for 10 minutes
+	alerts := rules.Evaluate(poolEvent("sp1", 0.91, now.Add(10*time.Minute)), now)
+	ecs.AssertEqualFatal(t, 1, len(alerts), "")
+	ecs.AssertEqual(t, "firing", alerts[0]["alert-status"], "")
+	ecs.AssertEqual(t, "ecsbeat-alert", alerts[0]["ecs-event-type"], "")
+	ecs.AssertEqual(t, "WARNING", alerts[0]["alert-severity"], "")
+	ecs.AssertEqual(t, 0.91, alerts[0]["alert-value"], "")
+	ecs.AssertEqual(t, "VDC1", alerts[0]["ecs-vdc-cfgname"], "")
+	ecs.AssertEqual(t, common.Time(now), alerts[0]["alert-since"], "")
+	id := alerts[0]["alert-id"]
+	// no repeat until renotify
+	ecs.AssertEqual(t, 0, len(rules.Evaluate(poolEvent("sp1", 0.92, now.Add(30*time.Minute)), now)), "")
+	alerts = rules.Evaluate(poolEvent("sp1", 0.92, now.Add(70*time.Minute)), now)
+	ecs.AssertEqualFatal(t, 1, len(alerts), "")
+	ecs.AssertEqual(t, true, alerts[0]["alert-renotified"], "")
+	ecs.AssertEqual(t, id, alerts[0]["alert-id"], "")
+
+	// states survive restarts
+	rules, err = NewRules([]*config.Rule{
+		{Name: "pool-full", Types: []string{"storagepools"}, Field: "capacityUtilization_ratio", Op: ">", Value: 0.85, For: 10 * time.Minute},
+	}, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	alerts = rules.Evaluate(poolEvent("sp1", 0.8, now.Add(80*time.Minute)), now)
+	ecs.AssertEqualFatal(t, 1, len(alerts), "")
+	ecs.AssertEqual(t, "resolved", alerts[0]["alert-status"], "")
+	ecs.AssertEqual(t, id, alerts[0]["alert-id"], "")
+	ecs.AssertEqual(t, 70*60.0, alerts[0]["alert-duration-seconds"], "")
+	ecs.AssertNotEqual(t, id, alerts[0]["ecs-doc-id"], "")
+
+	_, declared, err := templateFields()
+	ecs.AssertEqualFatal(t, nil, err, "")
+	for _, name := range flattenKeys("", alerts[0]) {
+		ecs.AssertEqual(t, true, inTemplate(declared, name), name)
+	}
+}
+
+// TestRulesRate ...
+func TestRulesRate(t *testing.T) {
+	registry, _ := NewRegistry("")
+	rules, err := NewRules([]*config.Rule{
+		{Name: "disks-dropped", Customers: []string{"c*"}, Field: "$.disks", Op: "<", Value: 0, Mode: "delta", Key: []string{}},
+		{Name: "fast-growth", Field: "used", Op: ">", Value: 10, Mode: "rate", Severity: "CRITICAL", Key: []string{}},
+	}, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	event := func(disks, used int, t time.Time) common.MapStr {
+		return common.MapStr{"@timestamp": common.Time(t), "ecs-customer": "c1", "disks": disks, "used": used}
+	}
+	ecs.AssertEqual(t, 0, len(rules.Evaluate(event(10, 0, now), now)), "")
+	alerts := rules.Evaluate(event(9, 1200, now.Add(time.Minute)), now)
+	ecs.AssertEqualFatal(t, 2, len(alerts), "")
+	ecs.AssertEqual(t, "disks-dropped", alerts[0]["alert-rule"], "")
+	ecs.AssertEqual(t, -1.0, alerts[0]["alert-value"], "")
+	ecs.AssertEqual(t, "CRITICAL", alerts[1]["alert-severity"], "")
+	ecs.AssertEqual(t, 20.0, alerts[1]["alert-value"], "")
+	// unchanged disk count resolves
+	alerts = rules.Evaluate(event(9, 2400, now.Add(2*time.Minute)), now)
+	ecs.AssertEqualFatal(t, 1, len(alerts), "")
+	ecs.AssertEqual(t, "resolved", alerts[0]["alert-status"], "")
+
+	for _, bad := range []*config.Rule{
+		{Field: "a", Op: ">", Value: 1},
+		{Name: "r", Field: "a", Op: "=~", Value: 1},
+		{Name: "r", Field: "a", Op: ">", Value: "x"},
+		{Name: "r", Field: "a", Op: ">", Value: 1, Mode: "avg"},
+		{Name: "r", Field: "a.*", Op: ">", Value: 1},
+	} {
+		_, err := NewRules([]*config.Rule{bad}, registry)
+		ecs.AssertNotEqual(t, nil, err, "")
+	}
+}
+
+// TestRulesPipeline ...
+func TestRulesPipeline(t *testing.T) {
+	registry, _ := NewRegistry("")
+	rules, err := NewRules([]*config.Rule{
+		{Name: "pool-full", Types: []string{"storagepools"}, Field: "capacityUtilization_ratio", Op: ">", Value: 0.85},
+	}, registry)
+	ecs.AssertEqualFatal(t, nil, err, "")
+	router, err := NewRouter(config.Config{Routing: config.Routing{Index: "ecsbeat-{type}"}})
+	ecs.AssertEqualFatal(t, nil, err, "")
+
+	done, in := make(chan struct{}), make(chan common.MapStr)
+	defer close(done)
+	out := router.Process(done, rules.Process(done, in))
+	now := time.Now()
+	go func() {
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			in <- poolEvent(fmt.Sprintf("sp%d", i), 0.9, now)
+		}
+	}()
+	indices := make(map[interface{}]int)
+	for event := range out {
+		indices[event["ecs-index"]]++
+	}
+	ecs.AssertEqual(t, map[interface{}]int{"ecsbeat-storagepools": 100, "ecsbeat-ecsbeat-alert": 100}, indices, "")
+}
@@ -0,0 +1,342 @@
+package beater
+
+import (
+	"expvar"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// coercionFailures counts values that don't match their declared type, per command type
+var coercionFailures = expvar.NewMap("ecsbeat.schema.coercion_failures")
+
+// SchemaField declares type of a field emitted by a command.
+// Type is one of keyword, text, long, integer, double, float, boolean and date.
+type SchemaField struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// SchemaPattern declares type of all fields whose name matches Match. They
+// become dynamic templates in the index template.
+type SchemaPattern struct {
+	Name  string
+	Match string
+	Type  string
+}
+
+// SchemaPatterns apply to fields of all commands not declared in Schemas
+var SchemaPatterns = []SchemaPattern{
+	{"boolean_acknowledged", "acknowledged", "boolean"},
+	{"integer_disks", "*Disks", "integer"},
+	{"longint_space", "*Current_Space", "long"},
+	{"longint_bytes", "*Current_Bytes", "long"},
+	{"longint_latency", "*Current_Latency", "long"},
+	{"longint_size", "*Current_TotalSize", "long"},
+	{"float_percent", "*Current_Percent", "float"},
+	{"float_bandwidth", "*Current_Bandwidth", "float"},
+	{"float_tps", "*Current_TPS", "float"},
+	{"float_rate", "*Current_Rate", "float"},
+	{"float_sub_rate", "*Current_*_Rate", "float"},
+	{"float_avgsize", "*AvgSize", "float"},
+	{"long_bytes", "*_bytes", "long"},
+	{"float_bytes_per_sec", "*_bytes_per_sec", "float"},
+	{"float_ratio", "*_ratio", "float"},
+	{"float_days_to", "daysTo*Percent", "float"},
+	{"double_delta", "*_delta", "double"},
+	{"double_counter_rate", "*_rate", "double"},
+}
+
+var nsBillingFields = []SchemaField{
+	{"namespace", "keyword", "Name of the namespace."},
+	{"total_size", "double", "Total size of objects in the namespace, in total_size_unit."},
+	{"total_size_unit", "keyword", "Unit of total_size."},
+	{"total_objects", "long", "Total number of objects in the namespace."},
+	{"sample_time", "date", "Time the billing info was sampled."},
+}
+
+var nsBillingSampleFields = []SchemaField{
+	{"namespace", "keyword", "Name of the namespace."},
+	{"total_size", "double", "Total size of objects in the namespace at the end of the sample, in total_size_unit."},
+	{"total_size_unit", "keyword", "Unit of total_size."},
+	{"total_objects", "long", "Total number of objects in the namespace at the end of the sample."},
+	{"objects_created", "long", "Number of objects created during the sample."},
+	{"objects_deleted", "long", "Number of objects deleted during the sample."},
+	{"added_size", "double", "Size of objects added during the sample."},
+	{"deleted_size", "double", "Size of objects deleted during the sample."},
+	{"ingress", "double", "Ingress traffic during the sample."},
+	{"egress", "double", "Egress traffic during the sample."},
+	{"sample_time_range.start_time", "date", "Start of the sample."},
+	{"sample_time_range.end_time", "date", "End of the sample."},
+}
+
+// bucketFields are added to billing fields of buckets
+var bucketFields = []SchemaField{
+	{"name", "keyword", "Name of the bucket."},
+	{"vpool_id", "keyword", "ID of the replication group of the bucket."},
+}
+
+var dashboardCapacityFields = []SchemaField{
+	{"numNodes", "integer", "Number of nodes."},
+	{"numGoodNodes", "integer", "Number of nodes in good state."},
+	{"numBadNodes", "integer", "Number of nodes in bad state."},
+	{"numMaintenanceNodes", "integer", "Number of nodes in maintenance mode."},
+	{"numDisks", "integer", "Number of disks."},
+	{"numGoodDisks", "integer", "Number of disks in good state."},
+	{"numBadDisks", "integer", "Number of disks in bad state."},
+	{"numMaintenanceDisks", "integer", "Number of disks in maintenance mode."},
+	{"diskSpaceTotalCurrent_Space", "long", "Total disk space in GB."},
+	{"diskSpaceFreeCurrent_Space", "long", "Free disk space in GB."},
+	{"diskSpaceAllocatedCurrent_Space", "long", "Allocated disk space in GB."},
+	{"capacityUsed_bytes", "long", "Used disk space in bytes."},
+	{"capacityUtilization_ratio", "float", "Ratio of used to total disk space."},
+}
+
+var dashboardPerformanceFields = []SchemaField{
+	{"nodeCpuUtilizationAvgCurrent_Percent", "float", "Average CPU utilization of nodes in percent."},
+	{"nodeMemoryUtilizationAvgCurrent_Percent", "float", "Average memory utilization of nodes in percent."},
+	{"nodeNicBandwidthAvgCurrent_Bandwidth", "float", "Average NIC bandwidth of nodes in MB/s."},
+	{"nodeNicUtilizationAvgCurrent_Percent", "float", "Average NIC utilization of nodes in percent."},
+	{"transactionReadLatencyCurrent_Latency", "long", "Read transaction latency in ms."},
+	{"transactionWriteLatencyCurrent_Latency", "long", "Write transaction latency in ms."},
+	{"transactionReadBandwidthCurrent_Bandwidth", "float", "Read bandwidth in MB/s."},
+	{"transactionWriteBandwidthCurrent_Bandwidth", "float", "Write bandwidth in MB/s."},
+	{"transactionReadTransactionsPerSecCurrent_TPS", "float", "Read transactions per second."},
+	{"transactionWriteTransactionsPerSecCurrent_TPS", "float", "Write transactions per second."},
+}
+
+var alertFields = []SchemaField{
+	{"id", "keyword", "ID of the alert."},
+	{"severity", "keyword", "Severity of the alert, like INFO, WARNING, ERROR and CRITICAL."},
+	{"symptomCode", "keyword", "Symptom code of the alert."},
+	{"description", "text", "Description of the alert."},
+	{"namespace", "keyword", "Namespace the alert belongs to."},
+	{"timestamp", "date", "Time the alert was raised on ECS."},
+	{"acknowledged", "boolean", "Whether the alert is acknowledged."},
+}
+
+// Schemas declares fields of each built-in command type after transformEvent
+var Schemas = map[string][]SchemaField{
+	"localzone": append(append([]SchemaField{
+		{"id", "keyword", "ID of the VDC."},
+		{"name", "keyword", "Name of the VDC."},
+	}, dashboardCapacityFields...), dashboardPerformanceFields...),
+	"nodes": append([]SchemaField{
+		{"id", "keyword", "ID of the node."},
+		{"displayName", "keyword", "Name of the node."},
+		{"numDisks", "integer", "Number of disks."},
+		{"numGoodDisks", "integer", "Number of disks in good state."},
+		{"numBadDisks", "integer", "Number of disks in bad state."},
+		{"diskSpaceTotalCurrent_Space", "long", "Total disk space in GB."},
+		{"diskSpaceFreeCurrent_Space", "long", "Free disk space in GB."},
+		{"diskSpaceAllocatedCurrent_Space", "long", "Allocated disk space in GB."},
+		{"nodeCpuUtilizationCurrent_Percent", "float", "CPU utilization in percent."},
+		{"nodeMemoryUtilizationCurrent_Percent", "float", "Memory utilization in percent."},
+		{"nodeMemoryUtilizationBytesCurrent_Bytes", "long", "Memory used in bytes."},
+		{"nodeNicBandwidthCurrent_Bandwidth", "float", "NIC bandwidth in MB/s."},
+		{"nodeNicReceivedBandwidthCurrent_Bandwidth", "float", "NIC received bandwidth in MB/s."},
+		{"nodeNicTransmittedBandwidthCurrent_Bandwidth", "float", "NIC transmitted bandwidth in MB/s."},
+		{"nodeNicUtilizationCurrent_Percent", "float", "NIC utilization in percent."},
+	}, dashboardPerformanceFields[4:]...),
+	"storagepools": append([]SchemaField{
+		{"id", "keyword", "ID of the storage pool."},
+		{"name", "keyword", "Name of the storage pool."},
+	}, dashboardCapacityFields...),
+	"replicationgroups": {
+		{"id", "keyword", "ID of the replication group."},
+		{"name", "keyword", "Name of the replication group."},
+		{"replicationIngressTrafficCurrent_Bandwidth", "float", "Replication ingress traffic in MB/s."},
+		{"replicationEgressTrafficCurrent_Bandwidth", "float", "Replication egress traffic in MB/s."},
+		{"chunksRepoPendingReplicationTotalSize", "long", "Size of repo chunks pending replication in bytes."},
+		{"chunksJournalPendingReplicationTotalSize", "long", "Size of journal chunks pending replication in bytes."},
+		{"chunksPendingXorTotalSize", "long", "Size of chunks pending XOR in bytes."},
+	},
+	"disks": {
+		{"id", "keyword", "ID of the disk."},
+		{"displayName", "keyword", "Name of the disk."},
+		{"healthStatus", "keyword", "Health status of the disk."},
+		{"diskSpaceTotalCurrent_Space", "long", "Total disk space in GB."},
+		{"diskSpaceFreeCurrent_Space", "long", "Free disk space in GB."},
+		{"diskSpaceAllocatedCurrent_Space", "long", "Allocated disk space in GB."},
+	},
+	"processes": {
+		{"id", "keyword", "ID of the process."},
+		{"name", "keyword", "Name of the process."},
+		{"pid", "long", "PID of the process."},
+		{"cpuUtilizationCurrent_Percent", "float", "CPU utilization in percent."},
+		{"memoryUtilizationBytesCurrent_Bytes", "long", "Memory used in bytes."},
+		{"memoryUtilizationPercentCurrent_Percent", "float", "Memory utilization in percent."},
+		{"numThreadsCurrent_Count", "integer", "Number of threads."},
+	},
+	"capacity": {
+		{"totalFree_gb", "long", "Free capacity of the system in GB."},
+		{"totalProvisioned_gb", "long", "Provisioned capacity of the system in GB."},
+		{"capacityUsed_bytes", "long", "Used capacity of the system in bytes."},
+		{"capacityUtilization_ratio", "float", "Ratio of used to provisioned capacity."},
+	},
+	"nsbilling":           append(append([]SchemaField{}, nsBillingFields...), NamespaceFields...),
+	"nsbillingsample":     append(append([]SchemaField{}, nsBillingSampleFields...), NamespaceFields...),
+	"bucketbilling":       append(append(append([]SchemaField{}, nsBillingFields...), bucketFields...), NamespaceFields...),
+	"bucketbillingsample": append(append(append([]SchemaField{}, nsBillingSampleFields...), bucketFields...), NamespaceFields...),
+	"alert":               alertFields,
+	"latestalert":         alertFields,
+	"auditevent": {
+		{"id", "keyword", "ID of the audit event."},
+		{"namespace", "keyword", "Namespace the event belongs to."},
+		{"serviceType", "keyword", "Service generating the event."},
+		{"eventType", "keyword", "Type of the event."},
+		{"description", "text", "Description of the event."},
+		{"userId", "keyword", "User triggering the event."},
+		{"resourceId", "keyword", "Resource the event is about."},
+		{"timestamp", "date", "Time the event happened on ECS."},
+	},
+	"forecast": {
+		{"forecast-source-type", "keyword", "Type of the command the forecast is derived from."},
+		{"storagepool-id", "keyword", "ID of the storage pool."},
+		{"storagepool-name", "keyword", "Name of the storage pool."},
+		{"capacityUsed_bytes", "long", "Used disk space of the storage pool in bytes."},
+		{"capacityTotal_bytes", "long", "Total disk space of the storage pool in bytes."},
+		{"capacityUtilization_ratio", "float", "Ratio of used to total disk space."},
+		{"forecastSamples", "integer", "Number of samples in the forecast history."},
+		{"growthRate_bytes_per_day", "float", "Growth of used disk space per day, least squares fit over the history."},
+	},
+	"change":       ChangeFields,
+	"inventory":    InventoryFields,
+	"health":       HealthFields,
+	alertEventType: RuleAlertFields,
+	"dtsummary": {
+		{"dt-count", "long", "Number of DTs of the VDC."},
+		{"dt-unready-count", "long", "Number of unready DTs."},
+		{"dt-unknown-count", "long", "Number of DTs in unknown status."},
+		{"dt-owner-count", "integer", "Number of nodes owning DTs."},
+		{"dt-imbalance", "float", "Coefficient of variation of DTs owned per node, 0 if DTs are evenly owned."},
+		{"dt-nodes-total", "integer", "Number of nodes queried."},
+		{"dt-nodes-available", "integer", "Number of nodes whose diagnostic port responded."},
+	},
+	"dtbalance": {
+		{"dt-owner-ip", "keyword", "IP of the node owning the DTs."},
+		{"dt-type", "keyword", "Type of the DTs."},
+		{"dt-level", "keyword", "Level of the DTs."},
+		{"dt-count", "long", "Number of DTs of the owner, type and level."},
+		{"dt-unready-count", "long", "Number of unready DTs."},
+		{"dt-unknown-count", "long", "Number of DTs in unknown status."},
+	},
+	"dtnode": {
+		{"dt-diagnostic-available", "boolean", "Whether the diagnostic port of the node responded."},
+		{"dt-owned-count", "long", "Number of DTs owned by the node."},
+	},
+	"dtinfo": {
+		{"dt-id", "keyword", "ID of the directory table."},
+		{"dt-created", "keyword", "Whether creation of the DT is completed."},
+		{"dt-error", "keyword", "Error of the DT if it's not ready."},
+		{"dt-down", "long", "1 if the DT is unready, 0 otherwise."},
+		{"dt-ready", "long", "1 if the DT is ready, 0 otherwise."},
+		{"dt-level", "keyword", "Level of the DT."},
+		{"dt-owner-ip", "keyword", "IP of the node owning the DT."},
+		{"dt-partition", "keyword", "Partition of the DT."},
+		{"dt-status", "keyword", "Status of the DT: ready, unready or unknown."},
+		{"dt-type", "keyword", "Type of the DT."},
+		{"dt-type-level", "keyword", "Type and level of the DT."},
+		{"dt-unready-since", "date", "Time the DT stopped being ready, only if it's not ready."},
+	},
+	"dttransition": {
+		{"dt-id", "keyword", "ID of the directory table."},
+		{"dt-type", "keyword", "Type of the DT."},
+		{"dt-level", "keyword", "Level of the DT."},
+		{"dt-owner-ip", "keyword", "IP of the node owning the DT."},
+		{"dt-status", "keyword", "New status of the DT: ready, unready or unknown."},
+		{"dt-error", "keyword", "Error of the DT if it's not ready."},
+		{"dt-previous-status", "keyword", "Previous status of the DT."},
+		{"dt-previous-error", "keyword", "Previous error of the DT."},
+		{"dt-previous-since", "date", "Time the DT entered its previous status."},
+		{"dt-previous-seconds", "double", "How long the previous status lasted, in seconds."},
+		{"dt-unready-seconds", "double", "How long the DT was not ready, only on recovery."},
+	},
+}
+
+// schemaTypes indexes Schemas by command type and field name
+var schemaTypes = func() map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for etype, fields := range Schemas {
+		result[etype] = make(map[string]string)
+		for _, f := range fields {
+			result[etype][f.Name] = f.Type
+		}
+	}
+	return result
+}()
+
+// canonicalTypes are types of fields normalised to canonical units
+var canonicalTypes = map[string]string{
+	"bytes":         "long",
+	"bytes_per_sec": "float",
+	"ratio":         "float",
+}
+
+// schemaFields returns declared fields of command type etype, including
+// fields normalised to canonical units
+func schemaFields(etype string) []SchemaField {
+	result := append([]SchemaField{}, Schemas[etype]...)
+	for _, f := range Schemas[etype] {
+		if canonical := canonicalOf(unitOf(etype, f.Name, nil)); canonical != "" {
+			result = append(result, SchemaField{
+				Name:        normalizedName(f.Name, canonical),
+				Type:        canonicalTypes[canonical],
+				Description: fmt.Sprintf("%s Normalised to %s.", f.Description, strings.Replace(canonical, "_", " ", -1)),
+			})
+		}
+	}
+	return result
+}
+
+// fieldType returns declared type of field name of command type etype
+func fieldType(etype, name string) string {
+	if typ, ok := schemaTypes[etype][name]; ok {
+		return typ
+	}
+	for _, p := range SchemaPatterns {
+		if ok, _ := path.Match(p.Match, name); ok {
+			return p.Type
+		}
+	}
+	return ""
+}
+
+// coerceEvent converts top level values of event to their declared types.
+// Values failing to convert are kept as is and counted.
+func coerceEvent(etype string, event map[string]interface{}) {
+	for k, v := range event {
+		if v == nil {
+			continue
+		}
+		typ := fieldType(etype, k)
+		var castType string
+		switch typ {
+		case "long", "integer":
+			castType = "long"
+		case "double", "float":
+			castType = "double"
+		case "boolean":
+			castType = "bool"
+		case "date":
+			castType = "date"
+		case "keyword", "text":
+			if _, ok := v.(string); !ok {
+				if _, ok := v.(map[string]interface{}); !ok {
+					event[k] = fmt.Sprint(v)
+				}
+			}
+			continue
+		default:
+			continue
+		}
+		casted, err := castValue(v, castType)
+		if err != nil {
+			coercionFailures.Add(etype, 1)
+			debugf("%s: coerce %s to %s: %v", etype, k, typ, err)
+			continue
+		}
+		event[k] = casted
+	}
+}
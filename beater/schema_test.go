@@ -0,0 +1,42 @@
+package beater
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// coercionFailureCount reads coercionFailures of etype, which are counted
+// across tests
+func coercionFailureCount(etype string) int64 {
+	if v, ok := coercionFailures.Get(etype).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// TestCoerceEvent ...
+func TestCoerceEvent(t *testing.T) {
+	event := map[string]interface{}{
+		"id":                                   float64(12),
+		"numNodes":                             "4",
+		"diskSpaceTotalCurrent_Space":          float64(12345678901),
+		"nodeCpuUtilizationAvgCurrent_Percent": "1.5",
+		"transactionErrorsCurrent_Rate":        "0.25",
+		"numBadDisks":                          "n/a",
+		"unknown":                              "1",
+	}
+	failures := coercionFailureCount("localzone")
+	coerceEvent("localzone", event)
+	ecs.AssertEqual(t, map[string]interface{}{
+		"id":                                   "12",
+		"numNodes":                             int64(4),
+		"diskSpaceTotalCurrent_Space":          int64(12345678901),
+		"nodeCpuUtilizationAvgCurrent_Percent": 1.5,
+		"transactionErrorsCurrent_Rate":        0.25,
+		"numBadDisks":                          "n/a",
+		"unknown":                              "1",
+	}, event, "")
+	ecs.AssertEqual(t, failures+1, coercionFailureCount("localzone"), "")
+}
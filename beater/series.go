@@ -0,0 +1,214 @@
+package beater
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+)
+
+// maxSeriesAge is how long the latest sample of a series no longer returned,
+// like the one of a removed node, is remembered after the latest sample of
+// its customer and VDC
+const maxSeriesAge = 24 * time.Hour
+
+// SeriesMode expands ECS dashboard time series into one event per sample
+type SeriesMode struct {
+	DataType string
+	Windows  int
+	mutex    sync.Mutex
+	// timestamp of the latest emitted sample per customer and VDC, and per
+	// series, so that samples returned again by overlapping windows are not
+	// published twice
+	last map[string]map[string]time.Time
+}
+
+// NewSeriesMode ...
+func NewSeriesMode(c *config.Series) (*SeriesMode, error) {
+	if c == nil {
+		return nil, nil
+	}
+	s := &SeriesMode{DataType: c.DataType, Windows: c.Windows, last: make(map[string]map[string]time.Time)}
+	switch s.DataType {
+	case "":
+		s.DataType = "range"
+	case "range", "history":
+	default:
+		return nil, fmt.Errorf("unsupported datatype %q", c.DataType)
+	}
+	if s.Windows <= 0 {
+		s.Windows = 2
+	}
+	return s, nil
+}
+
+// URI requests the window of Windows intervals ending at the latest interval boundary
+func (s *SeriesMode) URI(uri string, interval time.Duration, now time.Time) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	end := now.Truncate(interval)
+	start := end.Add(-time.Duration(s.Windows) * interval)
+	q := u.Query()
+	q.Set("dataType", s.DataType)
+	q.Set("startTime", start.UTC().Format(time.RFC3339)[:16])
+	q.Set("endTime", end.UTC().Format(time.RFC3339)[:16])
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Expand builds one event per sample not published yet, ordered by sample
+// time, or the event of d if it has no time series or no sample time is
+// valid. published must be called once the events are published, samples are
+// built again until then.
+func (s *SeriesMode) Expand(cmd *Command, config *ClusterConfig, d map[string]interface{}, vdc, node string) ([]common.MapStr, func()) {
+	samples := seriesSamples(d)
+	times := make([]time.Time, 0, len(samples))
+	byTime := make(map[time.Time]map[string]interface{}, len(samples))
+	for ts, sample := range samples {
+		t, err := parseTime(ts)
+		if err != nil {
+			debugf("%s: invalid sample time %q", cmd.Type, ts)
+			continue
+		}
+		times = append(times, t)
+		byTime[t] = sample
+	}
+	if len(times) == 0 {
+		return buildEvent(cmd, config, d, vdc, node), func() {}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	id, _ := d["id"].(string)
+	group := config.CustomerName + "/" + vdc
+	key := fmt.Sprintf("%s/%s/%s", cmd.Type, node, id)
+	s.mutex.Lock()
+	last := s.last[group][key]
+	s.mutex.Unlock()
+
+	var result []common.MapStr
+	for _, t := range times {
+		if !t.After(last) {
+			continue
+		}
+		event := copyMap(d)
+		for k, v := range byTime[t] {
+			event[k] = v
+		}
+		result = append(result, buildEventAt(cmd, config, event, vdc, node, t)...)
+	}
+	published := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		series, ok := s.last[group]
+		if !ok {
+			series = make(map[string]time.Time)
+			s.last[group] = series
+		}
+		latest := times[len(times)-1]
+		if latest.After(series[key]) {
+			series[key] = latest
+		}
+		for k, t := range series {
+			if latest.Sub(t) > maxSeriesAge {
+				delete(series, k)
+			}
+		}
+	}
+	return result, published
+}
+
+// seriesName is the name of time series key in events, with the Current
+// infix fields of dataType=current have, so that samples are named, coerced
+// and normalised the same way as current values
+func seriesName(key string) string {
+	if strings.HasSuffix(key, "Current") {
+		return key
+	}
+	return key + "Current"
+}
+
+// seriesSamples removes every time series from d and returns their samples
+// grouped by `t`. Fields are named the same way as transformEvent does.
+func seriesSamples(d map[string]interface{}) map[string]map[string]interface{} {
+	samples := make(map[string]map[string]interface{})
+	collect := func(key string, entries []interface{}) {
+		for _, e := range entries {
+			entry := e.(map[string]interface{})
+			t := fmt.Sprint(entry["t"])
+			if f, ok := entry["t"].(float64); ok {
+				// JSON numbers are decoded as float64, avoid exponent format
+				t = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			if _, ok := samples[t]; !ok {
+				samples[t] = make(map[string]interface{})
+			}
+			for k, v := range entry {
+				if k != "t" {
+					samples[t][key+"_"+k] = v
+				}
+			}
+		}
+	}
+	for k, v := range d {
+		switch val := v.(type) {
+		case []interface{}:
+			if isSeries(val) {
+				collect(seriesName(k), val)
+				delete(d, k)
+			}
+		case map[string]interface{}:
+			if len(val) == 0 {
+				continue
+			}
+			all := true
+			for _, v1 := range val {
+				if entries, ok := v1.([]interface{}); !ok || !isSeries(entries) {
+					all = false
+					break
+				}
+			}
+			if all {
+				for k1, v1 := range val {
+					collect(seriesName(k)+"_"+k1, v1.([]interface{}))
+				}
+				delete(d, k)
+			}
+		}
+	}
+	return samples
+}
+
+func isSeries(entries []interface{}) bool {
+	if len(entries) == 0 {
+		return false
+	}
+	for _, e := range entries {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := m["t"]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok {
+			v = copyMap(sub)
+		}
+		result[k] = v
+	}
+	return result
+}
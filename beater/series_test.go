@@ -0,0 +1,94 @@
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+func sampleRecord() map[string]interface{} {
+	return map[string]interface{}{
+		"id": "node1",
+		"nodeCpuUtilization": []interface{}{
+			map[string]interface{}{"Percent": "1.5", "t": "1490000300"},
+			map[string]interface{}{"Percent": "2.5", "t": "1490000000"},
+		},
+		"transactionErrors": map[string]interface{}{
+			"all": []interface{}{
+				map[string]interface{}{"Rate": "0", "t": "1490000000"},
+			},
+		},
+	}
+}
+
+// TestSeriesExpand ...
+func TestSeriesExpand(t *testing.T) {
+	s, err := NewSeriesMode(&config.Series{})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	ecs.AssertEqual(t, "range", s.DataType, "")
+	ecs.AssertEqual(t, 2, s.Windows, "")
+
+	cmd := &Command{Type: "nodes", Series: s}
+	cfg := &ClusterConfig{CustomerName: "c1", Vdcs: map[string]*Vdc{}}
+	events, published := s.Expand(cmd, cfg, sampleRecord(), "", "")
+	ecs.AssertEqualFatal(t, 2, len(events), "")
+	ecs.AssertEqual(t, common.Time(time.Unix(1490000000, 0)), events[0]["@timestamp"], "")
+	// samples are named, coerced and normalised like current values
+	ecs.AssertEqual(t, 0.025, events[0]["nodeCpuUtilizationCurrent_ratio"], "")
+	ecs.AssertEqual(t, 0.0, events[0]["transactionErrorsCurrent_all_Rate"], "")
+	ecs.AssertEqual(t, "node1", events[0]["id"], "")
+	ecs.AssertEqual(t, 0.015, events[1]["nodeCpuUtilizationCurrent_ratio"], "")
+
+	// samples not published yet are built again
+	events, published = s.Expand(cmd, cfg, sampleRecord(), "", "")
+	ecs.AssertEqual(t, 2, len(events), "")
+	published()
+
+	// samples already published are skipped
+	events, _ = s.Expand(cmd, cfg, sampleRecord(), "", "")
+	ecs.AssertEqual(t, 0, len(events), "")
+
+	// records without time series are published as they are
+	events, _ = s.Expand(cmd, cfg, map[string]interface{}{"id": "node2", "numGoodDisks": "3"}, "", "")
+	ecs.AssertEqualFatal(t, 1, len(events), "")
+	ecs.AssertEqual(t, "node2", events[0]["id"], "")
+
+	// sample times decoded as numbers
+	record := map[string]interface{}{"id": "node3", "nodeCpuUtilization": []interface{}{
+		map[string]interface{}{"Percent": "1.5", "t": float64(1490000000)},
+	}}
+	events, _ = s.Expand(cmd, cfg, record, "", "")
+	ecs.AssertEqualFatal(t, 1, len(events), "")
+	ecs.AssertEqual(t, common.Time(time.Unix(1490000000, 0)), events[0]["@timestamp"], "")
+
+	// records without valid sample time are not dropped
+	record = map[string]interface{}{"id": "node4", "nodeCpuUtilization": []interface{}{
+		map[string]interface{}{"Percent": "1.5", "t": "soon"},
+	}}
+	events, _ = s.Expand(cmd, cfg, record, "", "")
+	ecs.AssertEqualFatal(t, 1, len(events), "")
+	ecs.AssertEqual(t, "node4", events[0]["id"], "")
+
+	// series not returned anymore are forgotten after maxSeriesAge
+	later := float64(time.Unix(1490000000, 0).Add(maxSeriesAge + time.Hour).Unix())
+	_, published = s.Expand(cmd, cfg, map[string]interface{}{"id": "node5", "nodeCpuUtilization": []interface{}{
+		map[string]interface{}{"Percent": "1.5", "t": later},
+	}}, "", "")
+	published()
+	ecs.AssertEqual(t, 1, len(s.last["c1/"]), "")
+}
+
+// TestSeriesURI ...
+func TestSeriesURI(t *testing.T) {
+	s, _ := NewSeriesMode(&config.Series{DataType: "history", Windows: 3})
+	now := time.Date(2017, 3, 1, 10, 7, 0, 0, time.UTC)
+	ecs.AssertEqual(t,
+		"/dashboard/zones/localzone?dataType=history&endTime=2017-03-01T10%3A05&startTime=2017-03-01T09%3A50",
+		s.URI("/dashboard/zones/localzone?dataType=current", 5*time.Minute, now), "")
+
+	_, err := NewSeriesMode(&config.Series{DataType: "current"})
+	ecs.AssertNotEqual(t, nil, err, "")
+}
@@ -0,0 +1,216 @@
+package beater
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/yangb8/ecsbeat/config"
+)
+
+const (
+	defaultSinkTimeout  = 10 * time.Second
+	defaultSinkRetries  = 3
+	defaultRetryBackoff = time.Second
+	defaultWebhookBody  = `{{json .Event}}`
+	defaultEmailSubject = `[{{.Severity}}] {{.Customer}}: {{.Title}}`
+	defaultEmailBody    = `{{range $k, $v := .Event}}{{$k}}: {{$v}}
+{{end}}`
+)
+
+// Notification is what templates of sinks are executed with
+type Notification struct {
+	Customer string
+	Type     string
+	Severity string
+	Title    string
+	Event    map[string]interface{}
+}
+
+// Sink sends notifications
+type Sink interface {
+	Send(n *Notification) error
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseTemplate(name, text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+func render(t *template.Template, n *Notification) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, n); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// NewSink validates c and creates the sink of its type
+func NewSink(c *config.Sink) (Sink, error) {
+	switch c.Type {
+	case "webhook":
+		return newWebhookSink(c)
+	case "email":
+		return newEmailSink(c)
+	}
+	return nil, fmt.Errorf("unknown type %q", c.Type)
+}
+
+// webhookSink posts notifications rendered by body to url, retrying on
+// network errors, 429 and 5xx responses
+type webhookSink struct {
+	url     string
+	method  string
+	headers map[string]string
+	body    *template.Template
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+func newWebhookSink(c *config.Sink) (*webhookSink, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	body, err := parseTemplate("body", c.Body, defaultWebhookBody)
+	if err != nil {
+		return nil, err
+	}
+	s := &webhookSink{
+		url:     c.URL,
+		method:  c.Method,
+		headers: c.Headers,
+		body:    body,
+		retries: c.Retries,
+		backoff: c.RetryBackoff,
+		client:  &http.Client{Timeout: c.Timeout},
+	}
+	if s.method == "" {
+		s.method = "POST"
+	}
+	// 0 is the default, negative retries turn retrying off
+	if s.retries == 0 {
+		s.retries = defaultSinkRetries
+	} else if s.retries < 0 {
+		s.retries = 0
+	}
+	if s.backoff == 0 {
+		s.backoff = defaultRetryBackoff
+	}
+	if s.client.Timeout == 0 {
+		s.client.Timeout = defaultSinkTimeout
+	}
+	return s, nil
+}
+
+func (s *webhookSink) Send(n *Notification) error {
+	body, err := render(s.body, n)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = s.post(body); err == nil || !retry || attempt >= s.retries {
+			return err
+		}
+		time.Sleep(s.backoff << uint(attempt))
+	}
+}
+
+// post sends body once, it returns whether failures are worth retrying
+func (s *webhookSink) post(body string) (bool, error) {
+	req, err := http.NewRequest(s.method, s.url, strings.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		fmt.Errorf("%s %s: %s", s.method, s.url, resp.Status)
+}
+
+// emailSink mails notifications through an SMTP server
+type emailSink struct {
+	host    string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	subject *template.Template
+	body    *template.Template
+}
+
+func newEmailSink(c *config.Sink) (*emailSink, error) {
+	if c.Host == "" || c.From == "" || len(c.To) == 0 {
+		return nil, fmt.Errorf("host, from and to are required")
+	}
+	subject, err := parseTemplate("subject", c.Subject, defaultEmailSubject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parseTemplate("body", c.Body, defaultEmailBody)
+	if err != nil {
+		return nil, err
+	}
+	s := &emailSink{host: c.Host, from: c.From, to: c.To, subject: subject, body: body}
+	if c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Host)
+		s.auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	return s, nil
+}
+
+func (s *emailSink) Send(n *Notification) error {
+	subject, err := render(s.subject, n)
+	if err != nil {
+		return err
+	}
+	body, err := render(s.body, n)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"From":         s.from,
+		"To":           strings.Join(s.to, ", "),
+		"Subject":      strings.Replace(subject, "\n", " ", -1),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Content-Type": "text/plain; charset=UTF-8",
+	}
+	var keys []string
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var msg bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&msg, "%s: %s\r\n", k, headers[k])
+	}
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(s.host, s.auth, s.from, s.to, msg.Bytes())
+}
@@ -0,0 +1,156 @@
+package beater
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yangb8/ecsbeat/config"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestWebhookSink ...
+func TestWebhookSink(t *testing.T) {
+	var (
+		calls  int
+		bodies []string
+		tokens []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		tokens = append(tokens, r.Header.Get("X-Token"))
+		// fails once before accepting
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(&config.Sink{
+		Type:         "webhook",
+		URL:          server.URL,
+		Headers:      map[string]string{"X-Token": "secret"},
+		Body:         `{"text": "{{.Severity}} {{.Customer}}: {{.Title}}"}`,
+		RetryBackoff: time.Millisecond,
+	})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	n := &Notification{Customer: "c1", Severity: "CRITICAL", Title: "disk failed"}
+	ecs.AssertEqual(t, nil, sink.Send(n), "")
+	ecs.AssertEqual(t, 2, calls, "")
+	ecs.AssertEqual(t, `{"text": "CRITICAL c1: disk failed"}`, bodies[1], "")
+	ecs.AssertEqual(t, "secret", tokens[1], "")
+
+	// client errors are not retried, and the event is sent in JSON by default
+	calls = 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	sink, err = NewSink(&config.Sink{Type: "webhook", URL: server.URL, RetryBackoff: time.Millisecond})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	n.Event = map[string]interface{}{"id": "a1"}
+	ecs.AssertNotEqual(t, nil, sink.Send(n), "")
+	ecs.AssertEqual(t, 1, calls, "")
+	ecs.AssertEqual(t, `{"id":"a1"}`, bodies[len(bodies)-1], "")
+
+	// retrying is turned off by negative retries
+	calls = 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	sink, err = NewSink(&config.Sink{Type: "webhook", URL: server.URL, Retries: -1, RetryBackoff: time.Millisecond})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	ecs.AssertNotEqual(t, nil, sink.Send(n), "")
+	ecs.AssertEqual(t, 1, calls, "")
+
+	_, err = NewSink(&config.Sink{Type: "webhook"})
+	ecs.AssertNotEqual(t, nil, err, "")
+	_, err = NewSink(&config.Sink{Type: "pager", URL: server.URL})
+	ecs.AssertNotEqual(t, nil, err, "")
+}
+
+// fakeSMTP accepts one connection at a time and sends every message it
+// receives to messages
+func fakeSMTP(t *testing.T, messages chan<- string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ecs.AssertEqualFatal(t, nil, err, "")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+			reply("220 localhost ESMTP")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				cmd := strings.ToUpper(strings.TrimSpace(line))
+				switch {
+				case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+					reply("250 localhost")
+				case cmd == "DATA":
+					reply("354 go ahead")
+					var msg []string
+					for {
+						l, err := r.ReadString('\n')
+						if err != nil || l == ".\r\n" {
+							break
+						}
+						msg = append(msg, l)
+					}
+					messages <- strings.Join(msg, "")
+					reply("250 ok")
+				case cmd == "QUIT":
+					reply("221 bye")
+				default:
+					reply("250 ok")
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+// TestEmailSink ...
+func TestEmailSink(t *testing.T) {
+	messages := make(chan string, 1)
+	l := fakeSMTP(t, messages)
+	defer l.Close()
+
+	sink, err := NewSink(&config.Sink{
+		Type: "email",
+		Host: l.Addr().String(),
+		From: "ecsbeat@example.com",
+		To:   []string{"ops@example.com", "oncall@example.com"},
+	})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	err = sink.Send(&Notification{
+		Customer: "c1",
+		Severity: "CRITICAL",
+		Title:    "pool-full firing",
+		Event:    map[string]interface{}{"alert-rule": "pool-full", "alert-value": 0.9},
+	})
+	ecs.AssertEqualFatal(t, nil, err, "")
+	msg := <-messages
+	ecs.AssertEqual(t, true, strings.Contains(msg, "Subject: [CRITICAL] c1: pool-full firing\r\n"), msg)
+	ecs.AssertEqual(t, true, strings.Contains(msg, "To: ops@example.com, oncall@example.com\r\n"), msg)
+	ecs.AssertEqual(t, true, strings.Contains(msg, "\r\n\r\nalert-rule: pool-full\r\nalert-value: 0.9\r\n"), msg)
+
+	_, err = NewSink(&config.Sink{Type: "email", Host: l.Addr().String()})
+	ecs.AssertNotEqual(t, nil, err, "")
+}
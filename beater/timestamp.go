@@ -0,0 +1,69 @@
+package beater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yangb8/ecsbeat/config"
+)
+
+// TimestampSource reads the time an event happened on ECS from a field of
+// the ECS response
+type TimestampSource struct {
+	field  selector
+	layout string
+}
+
+// NewTimestampSource ...
+func NewTimestampSource(c *config.Timestamp) (*TimestampSource, error) {
+	if c == nil {
+		return nil, nil
+	}
+	field, err := parseSelector(c.Field)
+	if err != nil {
+		return nil, fmt.Errorf("field %q: %v", c.Field, err)
+	}
+	if field.hasWildcard() {
+		return nil, fmt.Errorf("field %q: wildcards are not allowed", c.Field)
+	}
+	return &TimestampSource{field, c.Layout}, nil
+}
+
+// Get returns the timestamp of d, false if ts is nil or the field is missing
+// or fails to parse
+func (ts *TimestampSource) Get(d map[string]interface{}) (time.Time, bool) {
+	if ts == nil {
+		return time.Time{}, false
+	}
+	v, ok := getPath(d, ts.field)
+	if !ok {
+		debugf("timestamp field %s not found", strings.Join(ts.field, "."))
+		return time.Time{}, false
+	}
+	t, err := ts.parse(strings.TrimSpace(fmt.Sprint(v)))
+	if err != nil {
+		debugf("timestamp field %s: %v", strings.Join(ts.field, "."), err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (ts *TimestampSource) parse(s string) (time.Time, error) {
+	switch ts.layout {
+	case "":
+		return parseTime(s)
+	case "epoch", "epoch_ms":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if ts.layout == "epoch" {
+			return time.Unix(n, 0), nil
+		}
+		return time.Unix(0, n*int64(time.Millisecond)), nil
+	default:
+		return time.Parse(ts.layout, s)
+	}
+}
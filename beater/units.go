@@ -0,0 +1,153 @@
+package beater
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+)
+
+// unit converts a value to its canonical unit: bytes, bytes_per_sec or ratio
+type unit struct {
+	factor    float64
+	canonical string
+}
+
+// units are looked up in upper case. ECS reports sizes in binary multiples.
+var units = map[string]unit{
+	"B":       {1, "bytes"},
+	"BYTES":   {1, "bytes"},
+	"KB":      {1 << 10, "bytes"},
+	"MB":      {1 << 20, "bytes"},
+	"GB":      {1 << 30, "bytes"},
+	"TB":      {1 << 40, "bytes"},
+	"PB":      {1 << 50, "bytes"},
+	"B/S":     {1, "bytes_per_sec"},
+	"KB/S":    {1 << 10, "bytes_per_sec"},
+	"MB/S":    {1 << 20, "bytes_per_sec"},
+	"GB/S":    {1 << 30, "bytes_per_sec"},
+	"PERCENT": {0.01, "ratio"},
+	"%":       {0.01, "ratio"},
+	"RATIO":   {1, "ratio"},
+}
+
+// UnitTables declares units of metrics per command type. A unit starting
+// with @ is read from the named field of the same event.
+var UnitTables = map[string]map[string]string{
+	"capacity": {
+		"totalFree_gb":        "GB",
+		"totalProvisioned_gb": "GB",
+	},
+	"replicationgroups": {
+		"chunksRepoPendingReplicationTotalSize":    "bytes",
+		"chunksJournalPendingReplicationTotalSize": "bytes",
+		"chunksPendingXorTotalSize":                "bytes",
+	},
+	"nsbilling":           nsBillingUnits,
+	"nsbillingsample":     nsBillingSampleUnits,
+	"bucketbilling":       nsBillingUnits,
+	"bucketbillingsample": nsBillingSampleUnits,
+}
+
+var nsBillingUnits = map[string]string{
+	"total_size": "@total_size_unit",
+}
+
+var nsBillingSampleUnits = map[string]string{
+	"total_size":   "@total_size_unit",
+	"added_size":   "@total_size_unit",
+	"deleted_size": "@total_size_unit",
+	"ingress":      "@total_size_unit",
+	"egress":       "@total_size_unit",
+}
+
+// UnitPatterns declares units of dashboard metrics of all command types
+var UnitPatterns = []struct {
+	Match string
+	Unit  string
+}{
+	{"*Current_Space", "GB"},
+	{"*Current_Bytes", "bytes"},
+	{"*Current_Bandwidth", "MB/s"},
+	{"*Current_Percent", "percent"},
+}
+
+// unitSuffixes are replaced by suffix of canonical unit in normalised field names
+var unitSuffixes = []string{"_Space", "_Bytes", "_Bandwidth", "_Percent", "_gb"}
+
+// normalizedName returns name of field normalised to canonical unit
+func normalizedName(name, canonical string) string {
+	for _, s := range unitSuffixes {
+		if strings.HasSuffix(name, s) {
+			name = strings.TrimSuffix(name, s)
+			break
+		}
+	}
+	return name + "_" + canonical
+}
+
+// unitOf returns the declared unit of field name, overrides take precedence
+// over UnitTables and UnitPatterns
+func unitOf(etype, name string, overrides map[string]string) string {
+	if u, ok := overrides[name]; ok {
+		return u
+	}
+	if u, ok := UnitTables[etype][name]; ok {
+		return u
+	}
+	for _, p := range UnitPatterns {
+		if ok, _ := path.Match(p.Match, name); ok {
+			return p.Unit
+		}
+	}
+	return ""
+}
+
+// canonicalOf returns canonical unit of u, units read from other fields are sizes
+func canonicalOf(u string) string {
+	if strings.HasPrefix(u, "@") {
+		return "bytes"
+	}
+	return units[strings.ToUpper(u)].canonical
+}
+
+// normalizeUnits adds every metric with a known unit to event in its
+// canonical unit, the original field is removed unless keepOriginal is set
+func normalizeUnits(etype string, event map[string]interface{}, overrides map[string]string, keepOriginal bool) {
+	keys := make([]string, 0, len(event))
+	for k := range event {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		u := unitOf(etype, k, overrides)
+		if u == "" || event[k] == nil {
+			continue
+		}
+		if strings.HasPrefix(u, "@") {
+			ref, ok := event[u[1:]]
+			if !ok {
+				continue
+			}
+			u = fmt.Sprint(ref)
+		}
+		conv, ok := units[strings.ToUpper(strings.TrimSpace(u))]
+		if !ok {
+			debugf("%s: unknown unit %q of %s", etype, u, k)
+			continue
+		}
+		v, err := castValue(event[k], "double")
+		if err != nil {
+			debugf("%s: normalise %s: %v", etype, k, err)
+			continue
+		}
+		value := v.(float64) * conv.factor
+		if conv.canonical == "bytes" {
+			event[normalizedName(k, conv.canonical)] = int64(math.Floor(value + 0.5))
+		} else {
+			event[normalizedName(k, conv.canonical)] = value
+		}
+		if !keepOriginal {
+			delete(event, k)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package beater
+
+import (
+	"testing"
+
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// TestNormalizeUnits ...
+func TestNormalizeUnits(t *testing.T) {
+	event := map[string]interface{}{
+		"diskSpaceTotalCurrent_Space":          int64(2),
+		"nodeCpuUtilizationAvgCurrent_Percent": 25.0,
+		"total_size":                           "1.5",
+		"total_size_unit":                      "MB",
+		"custom":                               "3",
+	}
+	normalizeUnits("nsbilling", event, map[string]string{"custom": "KB/s"}, false)
+	ecs.AssertEqual(t, map[string]interface{}{
+		"diskSpaceTotalCurrent_bytes":        int64(2 << 30),
+		"nodeCpuUtilizationAvgCurrent_ratio": 0.25,
+		"total_size_bytes":                   int64(1.5 * (1 << 20)),
+		"total_size_unit":                    "MB",
+		"custom_bytes_per_sec":               3072.0,
+	}, event, "")
+
+	event = map[string]interface{}{"totalFree_gb": "1"}
+	normalizeUnits("capacity", event, nil, true)
+	ecs.AssertEqual(t, map[string]interface{}{
+		"totalFree_gb":    "1",
+		"totalFree_bytes": int64(1 << 30),
+	}, event, "")
+}
@@ -15,11 +15,13 @@ var debugf = logp.MakeDebug("ecsbeat")
 type Worker struct {
 	cmd         *Command
 	ecsClusters *EcsClusters
+	// stopped is closed once the worker stops fetching
+	stopped chan struct{}
 }
 
 // NewWorker ...
 func NewWorker(cmd *Command, ecsClusters *EcsClusters) *Worker {
-	return &Worker{cmd, ecsClusters}
+	return &Worker{cmd, ecsClusters, make(chan struct{})}
 }
 
 // Start should be called only once in the life of a Worker.
@@ -38,27 +40,72 @@ func (w *Worker) Start(done <-chan struct{}, once bool) <-chan common.MapStr {
 
 	go func() {
 		wg.Wait()
+		close(w.stopped)
 		close(out)
 	}()
 
+	return filterEvents(done, out, w.cmd.Processors)
+}
+
+// eventProcessor runs processors on event, it returns nil if event is dropped
+type eventProcessor interface {
+	Run(event common.MapStr) common.MapStr
+}
+
+// eventProcessorFunc is an eventProcessor running a function
+type eventProcessorFunc func(event common.MapStr) common.MapStr
+
+// Run ...
+func (f eventProcessorFunc) Run(event common.MapStr) common.MapStr {
+	return f(event)
+}
+
+// filterEvents forwards events of in processed by procs, events dropped by
+// procs are neither published nor evaluated by rules
+func filterEvents(done <-chan struct{}, in <-chan common.MapStr, procs eventProcessor) <-chan common.MapStr {
+	if procs == nil {
+		return in
+	}
+	out := make(chan common.MapStr, 1)
+	go func() {
+		defer close(out)
+		for event := range in {
+			if event = procs.Run(event); event == nil {
+				continue
+			}
+			if !writeEvent(done, out, event) {
+				return
+			}
+		}
+	}()
 	return out
 }
 
+// startFetching fetches every customer on its own schedule, so a slow
+// customer only delays its own fetches
 func (w *Worker) startFetching(done <-chan struct{}, out chan<- common.MapStr, once bool) {
-	debugf("Starting %s", w)
-	defer debugf("Stopped %s", w)
-
-	// Fetch immediately.
-	err := w.fetch(done, out)
-	if err != nil {
-		logp.Err("%v", err)
+	var wg sync.WaitGroup
+	for _, ecs := range w.ecsClusters.EcsSlice {
+		wg.Add(1)
+		go func(ecs *EcsCluster) {
+			defer wg.Done()
+			w.startFetchingCustomer(done, out, ecs, once)
+		}(ecs)
 	}
+	wg.Wait()
+}
+
+func (w *Worker) startFetchingCustomer(done <-chan struct{}, out chan<- common.MapStr, ecs *EcsCluster, once bool) {
+	debugf("Starting %s of %s", w, ecs.CustomerName)
+	defer debugf("Stopped %s of %s", w, ecs.CustomerName)
 
-	if once {
+	// Fetch immediately.
+	if !w.fetch(done, out, ecs) || once {
 		return
 	}
 
-	// Start timer for future fetches.
+	// Start timer for future fetches. Ticks are dropped while a fetch
+	// takes longer than the interval.
 	t := time.NewTicker(w.cmd.Interval)
 	defer t.Stop()
 	for {
@@ -66,30 +113,31 @@ func (w *Worker) startFetching(done <-chan struct{}, out chan<- common.MapStr, o
 		case <-done:
 			return
 		case <-t.C:
-			err := w.fetch(done, out)
-			if err != nil {
-				logp.Err("%v", err)
+			if !w.fetch(done, out, ecs) {
+				return
 			}
 		}
 	}
 }
 
-// fetch does the actual work to query ECS
-func (w *Worker) fetch(done <-chan struct{}, out chan<- common.MapStr) error {
-	defer logp.Recover(fmt.Sprintf("recovered from panic while fetching "))
+// fetch does the actual work to query ECS of a customer, it returns false if
+// done is closed. It returns true after a panic, so that the next fetch goes
+// on.
+func (w *Worker) fetch(done <-chan struct{}, out chan<- common.MapStr, ecs *EcsCluster) (torun bool) {
+	torun = true
+	defer logp.Recover(fmt.Sprintf("recovered from panic while fetching %s", ecs.CustomerName))
 
-	// TODO, currently, each work for a particular type of query, shall we assign each work to one customer, or even every VDC?
-	for _, ecs := range w.ecsClusters.EcsSlice {
-		torun, err := GenerateEvents(w.cmd, ecs.Config, ecs.Client, done, out)
-		if !torun {
-			return nil
-		}
-		if err != nil {
-			logp.Err("%v", err)
-			continue
-		}
+	ok, err := GenerateEvents(w.cmd, ecs.Config, ecs.Client, done, out)
+	if !ok {
+		return false
+	}
+	if w.cmd.Level != "health" {
+		w.cmd.Health.ObserveFetch(ecs.Config.CustomerName, err == nil, time.Now())
+	}
+	if err != nil {
+		logp.Err("%v", err)
 	}
-	return nil
+	return true
 }
 
 func writeEvent(done <-chan struct{}, out chan<- common.MapStr, event common.MapStr) bool {
@@ -100,3 +148,12 @@ func writeEvent(done <-chan struct{}, out chan<- common.MapStr, event common.Map
 		return true
 	}
 }
+
+func writeEvents(done <-chan struct{}, out chan<- common.MapStr, events []common.MapStr) bool {
+	for _, event := range events {
+		if !writeEvent(done, out, event) {
+			return false
+		}
+	}
+	return true
+}
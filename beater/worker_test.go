@@ -0,0 +1,66 @@
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/yangb8/ecsbeat/ecs"
+)
+
+// dropInfo drops INFO events and the description of others, like drop_event
+// and drop_fields processors would
+type dropInfo struct{}
+
+func (dropInfo) Run(event common.MapStr) common.MapStr {
+	if event["severity"] == "INFO" {
+		return nil
+	}
+	delete(event, "description")
+	return event
+}
+
+// TestFilterEvents ...
+func TestFilterEvents(t *testing.T) {
+	done := make(chan struct{})
+	in := make(chan common.MapStr, 3)
+	in <- common.MapStr{"severity": "INFO", "description": "login"}
+	in <- common.MapStr{"severity": "CRITICAL", "description": "disk failed"}
+	in <- common.MapStr{"severity": "INFO"}
+	close(in)
+
+	var events []common.MapStr
+	for event := range filterEvents(done, in, dropInfo{}) {
+		events = append(events, event)
+	}
+	ecs.AssertEqual(t, []common.MapStr{{"severity": "CRITICAL"}}, events, "")
+
+	// without processors events are passed through
+	var c <-chan common.MapStr = in
+	ecs.AssertEqual(t, c, filterEvents(done, in, nil), "")
+}
+
+// TestFetchPanic ...
+func TestFetchPanic(t *testing.T) {
+	// config of the customer is missing, GenerateEvents panics
+	cluster := &EcsCluster{CustomerName: "c1"}
+	w := NewWorker(&Command{Type: "localzone", Level: "vdc", Interval: 10 * time.Millisecond},
+		&EcsClusters{EcsSlice: []*EcsCluster{cluster}})
+	done := make(chan struct{})
+	out := make(chan common.MapStr, 1)
+	ecs.AssertEqual(t, true, w.fetch(done, out, cluster), "fetching goes on after a panic")
+
+	// the customer is still fetched every interval until done is closed
+	stopped := make(chan struct{})
+	go func() {
+		w.startFetchingCustomer(done, out, cluster, false)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopped fetching after a panic")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(done)
+	<-stopped
+}
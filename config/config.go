@@ -3,7 +3,11 @@
 
 package config
 
-import "time"
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/processors"
+)
 
 // Customer ...
 type Customer struct {
@@ -20,22 +24,302 @@ type Customer struct {
 			IP string `config:"host"`
 		} `config:"nodes"`
 	} `config:"vdcs"`
+	// NamespaceMapping is a CSV file of namespace, tenant, cost center and
+	// business unit added to billing events, relative to config path
+	NamespaceMapping string `config:"namespacemapping"`
+	// Routes tell which sinks alerts of the customer are sent to, unless
+	// they are silenced
+	Routes   []*Route   `config:"routes"`
+	Silences []*Silence `config:"silences"`
+	// Routing overrides routing of events of the customer
+	Routing Routing `config:"routing"`
+	// Concurrency overrides concurrency.percustomer for the customer
+	Concurrency int `config:"concurrency"`
+}
+
+// Routing sets index and pipeline of events from templates of {customer},
+// {type} and {group}, group being billing, alerts, events or metrics. Empty
+// templates fall back to those of the customer, then those of ecsbeat.
+type Routing struct {
+	Index    string `config:"index"`
+	Pipeline string `config:"pipeline"`
+}
+
+// Sink is where alerts are sent, Type is webhook or email. Body and Subject
+// are Go templates of the notification, Body of webhooks defaults to the
+// alert event in JSON. Retries of webhooks default to 3 if 0, -1 for none.
+type Sink struct {
+	Name    string `config:"name"`
+	Type    string `config:"type"`
+	Subject string `config:"subject"`
+	Body    string `config:"body"`
+	// webhook
+	URL          string            `config:"url"`
+	Method       string            `config:"method"`
+	Headers      map[string]string `config:"headers"`
+	Timeout      time.Duration     `config:"timeout"`
+	Retries      int               `config:"retries"`
+	RetryBackoff time.Duration     `config:"retrybackoff"`
+	// email, Host is host:port of the SMTP server
+	Host     string   `config:"host"`
+	Username string   `config:"username"`
+	Password string   `config:"password"`
+	From     string   `config:"from"`
+	To       []string `config:"to"`
+}
+
+// Route sends alerts matching Types and Severities to Sinks. Types are
+// alert, latestalert or ecsbeat-alert, all of them if empty, and so are
+// Severities.
+type Route struct {
+	Types      []string `config:"types"`
+	Severities []string `config:"severities"`
+	Sinks      []string `config:"sinks"`
+}
+
+// Silence stops alerts matching Types, Severities and Rules from being sent
+// between Start and End, RFC3339 times open ended if empty. Rules are names
+// of rules of ecsbeat-alert, or symptom codes of ECS alerts.
+type Silence struct {
+	Types      []string `config:"types"`
+	Severities []string `config:"severities"`
+	Rules      []string `config:"rules"`
+	Start      string   `config:"start"`
+	End        string   `config:"end"`
+	Comment    string   `config:"comment"`
+}
+
+// Mapping describes how to shape the fields of a decoded ECS response.
+// Selectors are JSONPath-style, e.g. `$.nodes.name` or `$['key.with.dots']`,
+// and each path segment may contain `*` wildcards.
+type Mapping struct {
+	Include   []string        `config:"include"`
+	Exclude   []string        `config:"exclude"`
+	Rename    []*FieldRename  `config:"rename"`
+	Cast      []*FieldCast    `config:"cast"`
+	Namespace *FieldNamespace `config:"namespace"`
+}
+
+// FieldRename moves value of From to To
+type FieldRename struct {
+	From string `config:"from"`
+	To   string `config:"to"`
+}
+
+// FieldCast converts fields matched by Field to Type: long, double, bool or date
+type FieldCast struct {
+	Field string `config:"field"`
+	Type  string `config:"type"`
+}
+
+// FieldNamespace moves Fields, or all fields if empty, under Name
+type FieldNamespace struct {
+	Name   string   `config:"name"`
+	Fields []string `config:"fields"`
+}
+
+// Series enables emitting every sample of ECS dashboard time series.
+// DataType is range or history, and Windows is how many command intervals
+// each request looks back, so that a missed poll doesn't leave a hole.
+type Series struct {
+	DataType string `config:"datatype"`
+	Windows  int    `config:"windows"`
+}
+
+// Timestamp tells where to read @timestamp of events from ECS response.
+// Layout is a Go time layout, `epoch`, `epoch_ms`, or empty to detect
+// well known formats.
+type Timestamp struct {
+	Field  string `config:"field"`
+	Layout string `config:"layout"`
+}
+
+// FieldUnit declares unit of a top level field, like GB, MB/s or percent
+type FieldUnit struct {
+	Field string `config:"field"`
+	Unit  string `config:"unit"`
+}
+
+// Counters declares cumulative counters of a command by field name patterns.
+// MaxGap is how many intervals may be missed before the delta of a counter
+// is no longer computed from its previous value.
+type Counters struct {
+	Fields []string `config:"fields"`
+	MaxGap int      `config:"maxgap"`
+}
+
+// BucketDetail enables one event per bucket of billing commands, up to
+// MaxBuckets per namespace
+type BucketDetail struct {
+	Enabled    bool `config:"enabled"`
+	MaxBuckets int  `config:"maxbuckets"`
+}
+
+// NamespaceFilter selects namespaces by name patterns, all namespaces are
+// included if Include is empty
+type NamespaceFilter struct {
+	Include []string `config:"include"`
+	Exclude []string `config:"exclude"`
+}
+
+// Command ...
+type Command struct {
+	URI       string        `config:"uri"`
+	Type      string        `config:"type"`
+	Level     string        `config:"level"`
+	Interval  time.Duration `config:"interval"`
+	Enabled   bool          `config:"enabled"`
+	Mapping   *Mapping      `config:"mapping"`
+	Series    *Series       `config:"series"`
+	Timestamp *Timestamp    `config:"timestamp"`
+	// MaxLookback limits how far back time windowed commands catch up
+	// from their last checkpoint
+	MaxLookback time.Duration `config:"maxlookback"`
+	// Units adds to or overrides built-in units of fields normalised to
+	// bytes, bytes_per_sec or ratio, originals are kept if KeepOriginal is set
+	Units        []*FieldUnit `config:"units"`
+	KeepOriginal bool         `config:"keeporiginal"`
+	Counters     *Counters    `config:"counters"`
+	// BucketDetail, Namespaces and BatchSize apply to nsbilling and
+	// nsbillingsample only. BatchSize is how many namespaces are queried
+	// per request.
+	BucketDetail *BucketDetail    `config:"bucketdetail"`
+	Namespaces   *NamespaceFilter `config:"namespaces"`
+	BatchSize    int              `config:"batchsize"`
+	// RouteToNode sends queries of node level commands to the node being
+	// described, instead of any node of the VDC
+	RouteToNode bool `config:"routetonode"`
+	// ClusterView makes dtinfo query every node of every VDC instead of the
+	// first node responding, and publish balance statistics of DTs
+	ClusterView bool `config:"clusterview"`
+	// Processors are libbeat processors, like drop_event, drop_fields and
+	// include_fields with conditions, run on events of the command before
+	// they leave its worker
+	Processors processors.PluginConfig `config:"processors"`
+	// Routing overrides routing of events of the command type
+	Routing Routing `config:"routing"`
+}
+
+// Forecast configures forecasting of storage pool capacity. History is how
+// long used capacity is kept, and Thresholds are utilisation ratios to
+// estimate days until each storage pool reaches them.
+type Forecast struct {
+	History    time.Duration `config:"history"`
+	Thresholds []float64     `config:"thresholds"`
+}
+
+// Health configures health score of VDCs and customers. Every factor has a
+// penalty from 0 to 1, reaching 1 at its threshold, and the score is 100
+// less the weighted average penalty of factors observed. Window is how long
+// critical alerts stay open unless acknowledged, and how far back failed
+// fetches are counted.
+type Health struct {
+	Window     time.Duration    `config:"window"`
+	Weights    HealthWeights    `config:"weights"`
+	Thresholds HealthThresholds `config:"thresholds"`
+}
+
+// HealthWeights are relative weights of health factors
+type HealthWeights struct {
+	CriticalAlerts float64 `config:"criticalalerts"`
+	UnreadyDts     float64 `config:"unreadydts"`
+	BlockedNodes   float64 `config:"blockednodes"`
+	Capacity       float64 `config:"capacity"`
+	FailedFetches  float64 `config:"failedfetches"`
+}
+
+// HealthThresholds are values of health factors at full penalty. Capacity
+// utilisation is penalised from CapacityWarning on, BlockedNodes and
+// FailedFetches are ratios.
+type HealthThresholds struct {
+	CriticalAlerts  int     `config:"criticalalerts"`
+	UnreadyDts      int     `config:"unreadydts"`
+	BlockedNodes    float64 `config:"blockednodes"`
+	CapacityWarning float64 `config:"capacitywarning"`
+	Capacity        float64 `config:"capacity"`
+	FailedFetches   float64 `config:"failedfetches"`
+}
+
+// Concurrency bounds queries running at the same time. Workers is the limit
+// across all customers and commands, PerCustomer the limit of each customer,
+// so that no customer takes up all workers.
+type Concurrency struct {
+	Workers     int `config:"workers"`
+	PerCustomer int `config:"percustomer"`
+}
+
+// Rule raises ecsbeat-alert events when Field of events compares to Value
+// by Op, one of >, >=, <, <=, == and !=. Mode is value, delta or rate, the
+// last two comparing the change of Field since the previous event of the same
+// subject, rate being per second. The rule fires once the condition holds
+// for For, and fires again every Renotify until it's resolved. Customers and
+// Types are patterns of customer names and event types the rule applies to,
+// all if empty. Key lists fields telling subjects apart besides customer,
+// VDC and node, like id of storage pools.
+type Rule struct {
+	Name      string        `config:"name"`
+	Severity  string        `config:"severity"`
+	Message   string        `config:"message"`
+	Customers []string      `config:"customers"`
+	Types     []string      `config:"types"`
+	Field     string        `config:"field"`
+	Op        string        `config:"op"`
+	Value     interface{}   `config:"value"`
+	Mode      string        `config:"mode"`
+	For       time.Duration `config:"for"`
+	Renotify  time.Duration `config:"renotify"`
+	Key       []string      `config:"key"`
 }
 
 // Config ...
 type Config struct {
-	Period   time.Duration `config:"period"`
-	Once     bool          `config:"once"`
-	Commands []*struct {
-		URI      string        `config:"uri"`
-		Type     string        `config:"type"`
-		Level    string        `config:"level"`
-		Interval time.Duration `config:"interval"`
-		Enabled  bool          `config:"enabled"`
-	} `config:"commands"`
+	Period       time.Duration `config:"period"`
+	Once         bool          `config:"once"`
+	RegistryFile string        `config:"registryfile"`
+	DedupSize    int           `config:"dedupsize"`
+	Forecast     Forecast      `config:"forecast"`
+	Health       Health        `config:"health"`
+	Rules        []*Rule       `config:"rules"`
+	Sinks        []*Sink       `config:"sinks"`
+	// Layout of published events, legacy or ecs for Elastic Common Schema
+	Layout    string      `config:"layout"`
+	Routing   Routing     `config:"routing"`
+	Commands  []*Command  `config:"commands"`
 	Customers []*Customer `config:"customers"`
+
+	// Concurrency bounds queries of all customers running at the same time
+	Concurrency Concurrency `config:"concurrency"`
 }
 
 var DefaultConfig = Config{
-	Period: 60 * time.Second,
+	Period:       60 * time.Second,
+	RegistryFile: "registry",
+	Layout:       "legacy",
+	DedupSize:    10000,
+	Concurrency: Concurrency{
+		Workers:     16,
+		PerCustomer: 4,
+	},
+	Forecast: Forecast{
+		History:    7 * 24 * time.Hour,
+		Thresholds: []float64{0.8, 0.9, 1.0},
+	},
+	Health: Health{
+		Window: time.Hour,
+		Weights: HealthWeights{
+			CriticalAlerts: 30,
+			UnreadyDts:     20,
+			BlockedNodes:   20,
+			Capacity:       15,
+			FailedFetches:  15,
+		},
+		Thresholds: HealthThresholds{
+			CriticalAlerts:  5,
+			UnreadyDts:      10,
+			BlockedNodes:    0.5,
+			CapacityWarning: 0.8,
+			Capacity:        0.95,
+			FailedFetches:   0.5,
+		},
+	},
 }
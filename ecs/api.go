@@ -326,3 +326,53 @@ func GetNamespaceIDs(client *MgmtClient, vdc string) ([]string, error) {
 	}
 	return idslice, nil
 }
+
+// Namespace ...
+type Namespace struct {
+	ID                       string `json:"id"`
+	Name                     string `json:"name"`
+	DefaultDataServicesVpool string `json:"default_data_services_vpool"`
+	DefaultBucketBlockSize   int64  `json:"default_bucket_block_size"`
+	NamespaceAdmins          string `json:"namespace_admins"`
+	ExternalGroupAdmins      string `json:"external_group_admins"`
+}
+
+// GetNamespace ...
+func GetNamespace(client *MgmtClient, vdc, id string) (*Namespace, error) {
+	resp, err := client.GetQuery(fmt.Sprintf("/object/namespaces/namespace/%s.json", id), vdc)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := Namespace{}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StoragePoolNodes ...
+type StoragePoolNodes struct {
+	Embedded struct {
+		Instances []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"displayName"`
+		} `json:"_instances"`
+	} `json:"_embedded"`
+}
+
+// GetStoragePoolNodes ...
+func GetStoragePoolNodes(client *MgmtClient, vdc, id string) (*StoragePoolNodes, error) {
+	resp, err := client.GetQuery(fmt.Sprintf("/dashboard/storagepools/%s/nodes", id), vdc)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := StoragePoolNodes{}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
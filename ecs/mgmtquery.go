@@ -60,7 +60,11 @@ func (e *MgmtClient) PerformRequest(method, scheme, port, uri string, body io.Re
 	if err != nil {
 		return nil, 0, err
 	}
+	return e.performRequestOn(h, method, scheme, port, uri, body, bodyLength, headers, auth)
+}
 
+// performRequestOn sends request to host h
+func (e *MgmtClient) performRequestOn(h, method, scheme, port, uri string, body io.Reader, bodyLength int64, headers http.Header, auth Authentication) (*http.Response, int, error) {
 	host, pport := parseHostPort(h)
 	if port == "" {
 		if pport != "" {
@@ -164,9 +168,28 @@ func (e *MgmtClient) PostQueryBase(scheme, port, uri string, body io.Reader, bod
 	return e.QueryBaseWithRetry("POST", scheme, port, uri, body, bodyLength, headers, vdc)
 }
 
+// GetQueryOnNode sends Get request to node ip directly, it falls back to
+// other nodes of vdc if the node is blocked or fails
+func (e *MgmtClient) GetQueryOnNode(uri, vdc, ip string) (*http.Response, error) {
+	return e.queryWithRetry("GET", "https", "", uri, nil, 0, http.Header{}, vdc, ip)
+}
+
+// BlockedNodes returns how many nodes of vdc are blocked, and how many
+// nodes it has
+func (e *MgmtClient) BlockedNodes(vdc string) (int, int) {
+	return e.ecs.BlockedNodes(vdc)
+}
+
 // QueryBaseWithRetry does the general query to ECS with retry
 func (e *MgmtClient) QueryBaseWithRetry(method, scheme, port, uri string, body io.Reader, bodyLength int64, headers http.Header, vdc string) (resp *http.Response, err error) {
+	return e.queryWithRetry(method, scheme, port, uri, body, bodyLength, headers, vdc, "")
+}
+
+// queryWithRetry sends the first attempt to node ip if it's set and not
+// blocked, retries go to any available node of vdc
+func (e *MgmtClient) queryWithRetry(method, scheme, port, uri string, body io.Reader, bodyLength int64, headers http.Header, vdc, ip string) (resp *http.Response, err error) {
 	var status int
+	direct := ip != ""
 	for i := 0; i < 3; i++ {
 		if e.token == nil || e.token.Expired() {
 			if err = e.MgmtLogin(); err != nil {
@@ -174,7 +197,20 @@ func (e *MgmtClient) QueryBaseWithRetry(method, scheme, port, uri string, body i
 			}
 		}
 		token, _ := e.token.Get()
-		if resp, status, err = e.PerformRequest(method, scheme, port, uri, body, bodyLength, headers, &TokenAuth{token}, vdc); err != nil {
+		host, available := "", false
+		if direct {
+			direct = false
+			host, available = e.ecs.NodeHost(ip)
+			if !available {
+				logp.Info("[%s] node %s is blocked, falling back to other nodes", e.Name, ip)
+			}
+		}
+		if available {
+			resp, status, err = e.performRequestOn(host, method, scheme, port, uri, body, bodyLength, headers, &TokenAuth{token})
+		} else {
+			resp, status, err = e.PerformRequest(method, scheme, port, uri, body, bodyLength, headers, &TokenAuth{token}, vdc)
+		}
+		if err != nil {
 			if status == 401 {
 				e.token.ForceExpire()
 			}
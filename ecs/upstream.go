@@ -61,6 +61,33 @@ func (v *Vdc) BlockNode(host string, dur time.Duration) {
 	}
 }
 
+// NodeHost returns the configured host of node ip, whether it's not blocked,
+// and whether ip is a node of the VDC
+func (v *Vdc) NodeHost(ip string) (string, bool, bool) {
+	v.Lock()
+	defer v.Unlock()
+	for _, n := range v.Nodes {
+		if h, _ := parseHostPort(n.host); h == ip {
+			return n.host, time.Now().After(n.blockedUntil), true
+		}
+	}
+	return "", false, false
+}
+
+// BlockedNodes returns how many nodes are blocked, and how many nodes there are
+func (v *Vdc) BlockedNodes() (int, int) {
+	v.Lock()
+	defer v.Unlock()
+	now := time.Now()
+	var blocked int
+	for _, n := range v.Nodes {
+		if !now.After(n.blockedUntil) {
+			blocked++
+		}
+	}
+	return blocked, len(v.Nodes)
+}
+
 // NewEcs ...
 func NewEcs(vdcs map[string]*Vdc) *Ecs {
 	ecs := &Ecs{vdcs}
@@ -91,3 +118,23 @@ func (e *Ecs) BlockNode(host string, dur time.Duration) {
 		v.BlockNode(host, dur)
 	}
 }
+
+// NodeHost returns the host to query node ip directly, which is ip itself
+// unless it's configured with a port, and whether the node is not blocked
+func (e *Ecs) NodeHost(ip string) (string, bool) {
+	for _, v := range e.Vdcs {
+		if host, available, ok := v.NodeHost(ip); ok {
+			return host, available
+		}
+	}
+	return ip, true
+}
+
+// BlockedNodes returns how many nodes of vdcid are blocked, and how many
+// nodes it has
+func (e *Ecs) BlockedNodes(vdcid string) (int, int) {
+	if v, ok := e.Vdcs[vdcid]; ok {
+		return v.BlockedNodes()
+	}
+	return 0, 0
+}
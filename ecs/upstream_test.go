@@ -67,3 +67,36 @@ func TestEcs(t *testing.T) {
 		AssertNotEqual(t, "2.2.2.2", s, "")
 	}
 }
+
+// TestNodeHost ...
+func TestNodeHost(t *testing.T) {
+	ecs := NewEcs(map[string]*Vdc{
+		"vdc1": NewVdc("vdc1", []string{"1.1.1.1", "2.2.2.2:4443"}),
+	})
+	host, available := ecs.NodeHost("2.2.2.2")
+	AssertEqual(t, "2.2.2.2:4443", host, "")
+	AssertEqual(t, true, available, "")
+	// nodes not configured are queried directly
+	host, available = ecs.NodeHost("3.3.3.3")
+	AssertEqual(t, "3.3.3.3", host, "")
+	AssertEqual(t, true, available, "")
+	ecs.BlockNode("1.1.1.1", time.Second)
+	_, available = ecs.NodeHost("1.1.1.1")
+	AssertEqual(t, false, available, "")
+}
+
+// TestBlockedNodes ...
+func TestBlockedNodes(t *testing.T) {
+	ecs := NewEcs(map[string]*Vdc{
+		"vdc1": NewVdc("vdc1", []string{"1.1.1.1", "2.2.2.2"}),
+	})
+	blocked, total := ecs.BlockedNodes("vdc1")
+	AssertEqual(t, 0, blocked, "")
+	AssertEqual(t, 2, total, "")
+	ecs.BlockNode("2.2.2.2", time.Second)
+	blocked, total = ecs.BlockedNodes("vdc1")
+	AssertEqual(t, 1, blocked, "")
+	AssertEqual(t, 2, total, "")
+	_, total = ecs.BlockedNodes("vdc2")
+	AssertEqual(t, 0, total, "")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/elastic/beats/libbeat/beat"
@@ -9,6 +10,17 @@ import (
 )
 
 func main() {
+	// `ecsbeat generate fields|template|template-ecs` writes generated
+	// _meta/fields.yml, ecsbeat.template.json or ecsbeat.ecs.template.json
+	// to stdout
+	if len(os.Args) == 3 && os.Args[1] == "generate" {
+		if err := beater.Generate(os.Args[2], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	err := beat.Run("ecsbeat", "", beater.New)
 	if err != nil {
 		os.Exit(1)